@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// aliasesCommands are the subcommands of the aliases command.
+var aliasesCommands = map[string]func(configFile string, args []string) int{
+	"apply": aliasesApplyCommand,
+	"list":  aliasesListCommand,
+}
+
+// aliasesCommand runs one of the aliases subcommands.
+func aliasesCommand(configFile string, args []string) int {
+	if len(args) != 0 {
+		if c, ok := aliasesCommands[args[0]]; ok {
+			return c(configFile, args[1:])
+		}
+	}
+	names := make([]string, 0, len(aliasesCommands))
+	for name := range aliasesCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: kittehbooru aliases ["+strings.Join(names, "|")+"]")
+	return 2
+}
+
+// aliasesApplyCommand rewrites the tags of existing posts for active aliases.
+func aliasesApplyCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("aliases apply", flag.ExitOnError)
+	tag := fs.String("tag", "", "only apply the alias of this tag")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	db := database.OpenDB(configFile)
+
+	var n int
+	var err error
+	if *tag != "" {
+		aliases, lerr := db.TagAliases(ctx)
+		if lerr != nil {
+			log.Error().Err(lerr).Msg("Can't list aliases")
+			return 1
+		}
+		found := false
+		for _, a := range aliases {
+			if a.Antecedent == *tag && a.Status == types.AliasActive {
+				n, err = db.ApplyAlias(ctx, a.Antecedent, a.Consequent)
+				found = true
+			}
+		}
+		if !found {
+			log.Error().Str("tag", *tag).Msg("Tag has no active alias")
+			return 1
+		}
+	} else {
+		n, err = db.ApplyAliases(ctx)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Applying aliases failed")
+		return 1
+	}
+	log.Info().Int("posts", n).Msg("Aliases applied")
+	return 0
+}
+
+// aliasesListCommand prints every alias.
+func aliasesListCommand(configFile string, args []string) int {
+	db := database.OpenDB(configFile)
+
+	aliases, err := db.TagAliases(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("Can't list aliases")
+		return 1
+	}
+	for _, a := range aliases {
+		fmt.Printf("%s -> %s (%s)\n", a.Antecedent, a.Consequent, a.Status)
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a command line tool which can be run instead of the web server.
+type command struct {
+	// Usage is a short description of the arguments.
+	Usage string
+	// Run runs the command with the remaining arguments, returning a exit code.
+	Run func(configFile string, args []string) int
+}
+
+var commands = map[string]command{
+	"aliases": {
+		Usage: "aliases apply [-tag tag] | aliases list",
+		Run:   aliasesCommand,
+	},
+	"export": {
+		Usage: "export [-passwords] [-o file]",
+		Run:   exportCommand,
+	},
+	"fsck": {
+		Usage: "fsck [-quarantine dir | -delete] [-regenerate]",
+		Run:   fsckCommand,
+	},
+	"implications": {
+		Usage: "implications [-tag tag]",
+		Run:   implicationsCommand,
+	},
+	"restore": {
+		Usage: "restore [-i file]",
+		Run:   restoreCommand,
+	},
+	"storage": {
+		Usage: "storage migrate -from URI -to URI [-workers N] [-journal file] | storage genkey [-o file] | storage relayout -uri URI | storage check -uri URI",
+		Run:   storageCommand,
+	},
+	"tags": {
+		Usage: "tags rebuild",
+		Run:   tagsCommand,
+	},
+}
+
+// Run runs the command named by args[0], returning a exit code.
+func Run(configFile string, args []string) int {
+	c, ok := commands[args[0]]
+	if !ok {
+		usage()
+		return 2
+	}
+	return c.Run(configFile, args[1:])
+}
+
+// usage prints the usage of all commands.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: kittehbooru [-conf settings.yaml] [command]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].Usage)
+	}
+}
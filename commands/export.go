@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/rs/zerolog/log"
+)
+
+// exportCommand writes a backup archive of the instance.
+func exportCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	passwords := fs.Bool("passwords", false, "include password hashes and secrets")
+	output := fs.String("o", "-", "archive to write, - for stdout")
+	fs.Parse(args)
+
+	db := database.OpenDB(configFile)
+
+	var w io.WriteCloser = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Error().Err(err).Msg("Can't create archive")
+			return 1
+		}
+		w = f
+	}
+
+	err := db.Export(context.Background(), w, database.ExportOptions{Passwords: *passwords})
+	if err != nil {
+		log.Error().Err(err).Msg("Export failed")
+		w.Close()
+		return 1
+	}
+	if err := w.Close(); err != nil {
+		log.Error().Err(err).Msg("Can't close archive")
+		return 1
+	}
+	log.Info().Msg("Export finished")
+	return 0
+}
+
+// restoreCommand rebuilds a fresh instance from a backup archive.
+func restoreCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	input := fs.String("i", "-", "archive to read, - for stdin")
+	fs.Parse(args)
+
+	db := database.OpenDB(configFile)
+
+	var r io.ReadCloser = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Error().Err(err).Msg("Can't open archive")
+			return 1
+		}
+		r = f
+	}
+	defer r.Close()
+
+	if err := db.Restore(context.Background(), r); err != nil {
+		log.Error().Err(err).Msg("Restore failed")
+		return 1
+	}
+	log.Info().Msg("Restore finished")
+	return 0
+}
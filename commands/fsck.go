@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/rs/zerolog/log"
+)
+
+// fsckCommand checks the storage for missing, broken and orphaned files.
+func fsckCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quarantine := fs.String("quarantine", "", "move orphaned files into this directory")
+	deleteOrphans := fs.Bool("delete", false, "delete orphaned files")
+	regenerate := fs.Bool("regenerate", false, "regenerate missing and empty thumbnails")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	db := database.OpenDB(configFile)
+
+	report, err := db.Fsck(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Fsck failed")
+		return 1
+	}
+
+	for _, postID := range report.MissingContent {
+		fmt.Printf("missing content: post %d\n", postID)
+	}
+	for _, postID := range report.UnreadableContent {
+		fmt.Printf("unreadable content: post %d\n", postID)
+	}
+	for _, postID := range report.EmptyContent {
+		fmt.Printf("empty content: post %d\n", postID)
+	}
+	for _, postID := range report.MissingThumbnails {
+		fmt.Printf("missing thumbnail: post %d\n", postID)
+	}
+	for _, postID := range report.EmptyThumbnails {
+		fmt.Printf("empty thumbnail: post %d\n", postID)
+	}
+	for _, name := range report.OrphanContent {
+		fmt.Printf("orphan content: %s\n", name)
+	}
+	for _, name := range report.OrphanThumbnails {
+		fmt.Printf("orphan thumbnail: %s\n", name)
+	}
+
+	switch {
+	case *quarantine != "":
+		err = db.QuarantineFiles(ctx, db.ContentStorage, report.OrphanContent, *quarantine+"/content")
+		if err == nil {
+			err = db.QuarantineFiles(ctx, db.ThumbnailsStorage, report.OrphanThumbnails, *quarantine+"/thumbnails")
+		}
+	case *deleteOrphans:
+		err = db.DeleteFiles(ctx, db.ContentStorage, report.OrphanContent)
+		if terr := db.DeleteFiles(ctx, db.ThumbnailsStorage, report.OrphanThumbnails); err == nil {
+			err = terr
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Can't remove orphaned files")
+		return 1
+	}
+
+	if *regenerate {
+		db.RegenerateThumbnails(ctx, report.BrokenThumbnails())
+	}
+
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
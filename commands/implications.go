@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"context"
+	"flag"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/rs/zerolog/log"
+)
+
+// implicationsCommand adds implied tags to existing posts.
+func implicationsCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("implications", flag.ExitOnError)
+	tag := fs.String("tag", "", "only backfill the implications of this tag")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	db := database.OpenDB(configFile)
+
+	var n int
+	var err error
+	if *tag != "" {
+		n, err = db.BackfillImplication(ctx, *tag)
+	} else {
+		n, err = db.BackfillImplications(ctx)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Backfilling implications failed")
+		return 1
+	}
+	log.Info().Int("posts", n).Msg("Implications backfilled")
+	return 0
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/storage"
+	"github.com/NamedKitten/kittehbooru/storage/storagetest"
+	"github.com/rs/zerolog/log"
+)
+
+// storageCommands are the subcommands of the storage command.
+var storageCommands = map[string]func(configFile string, args []string) int{
+	"migrate":  storageMigrateCommand,
+	"genkey":   storageGenkeyCommand,
+	"relayout": storageRelayoutCommand,
+	"check":    storageCheckCommand,
+}
+
+// storageCommand runs one of the storage subcommands.
+func storageCommand(configFile string, args []string) int {
+	if len(args) != 0 {
+		if c, ok := storageCommands[args[0]]; ok {
+			return c(configFile, args[1:])
+		}
+	}
+	names := make([]string, 0, len(storageCommands))
+	for name := range storageCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: kittehbooru storage ["+strings.Join(names, "|")+"]")
+	return 2
+}
+
+// storageMigrateCommand copies every file from one storage backend to another.
+func storageMigrateCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("storage migrate", flag.ExitOnError)
+	from := fs.String("from", "", "storage URI to copy from")
+	to := fs.String("to", "", "storage URI to copy to")
+	workers := fs.Int("workers", 4, "number of files to copy at once")
+	journal := fs.String("journal", "storage-migrate.journal", "file recording copied files, used to resume")
+	fs.Parse(args)
+
+	opts := database.ReadSettings(configFile).StorageOptions()
+	fromStorage := storage.GetStorage(*from, opts)
+	toStorage := storage.GetStorage(*to, opts)
+	if fromStorage == nil || toStorage == nil {
+		fmt.Fprintln(os.Stderr, "Both -from and -to need to be valid storage URIs.")
+		return 2
+	}
+
+	err := storage.Migrate(context.Background(), fromStorage, toStorage, storage.MigrateOptions{
+		Workers: *workers,
+		Journal: *journal,
+		From:    *from,
+		To:      *to,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Storage migration failed, run again to resume")
+		return 1
+	}
+	return 0
+}
+
+// storageGenkeyCommand writes a new random key for encrypted storage.
+func storageGenkeyCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("storage genkey", flag.ExitOnError)
+	output := fs.String("o", "storage.key", "file to write the key to")
+	fs.Parse(args)
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Error().Err(err).Msg("Can't generate key")
+		return 1
+	}
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Error().Err(err).Msg("Can't create key file")
+		return 1
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		log.Error().Err(err).Msg("Can't write key file")
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		log.Error().Err(err).Msg("Can't write key file")
+		return 1
+	}
+	return 0
+}
+
+// relayouter is a storage which can move its files into a different directory layout.
+type relayouter interface {
+	Relayout(context.Context) (int, error)
+}
+
+// storageRelayoutCommand moves existing files into the layout given in a storage URI.
+func storageRelayoutCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("storage relayout", flag.ExitOnError)
+	uri := fs.String("uri", "", "storage URI with the new layout, such as file://data/content/?layout=hash")
+	fs.Parse(args)
+
+	s, ok := storage.GetStorage(*uri, database.ReadSettings(configFile).StorageOptions()).(relayouter)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "-uri needs to be a valid file storage URI.")
+		return 2
+	}
+	moved, err := s.Relayout(context.Background())
+	log.Info().Int("moved", moved).Msg("Relayout finished")
+	if err != nil {
+		log.Error().Err(err).Msg("Relayout failed")
+		return 1
+	}
+	return 0
+}
+
+// storageCheckCommand checks that a storage backend works correctly.
+func storageCheckCommand(configFile string, args []string) int {
+	fs := flag.NewFlagSet("storage check", flag.ExitOnError)
+	uri := fs.String("uri", "", "storage URI to check, files starting with "+storagetest.Prefix+" are created in it")
+	fs.Parse(args)
+
+	s := storage.GetStorage(*uri, database.ReadSettings(configFile).StorageOptions())
+	if s == nil {
+		fmt.Fprintln(os.Stderr, "-uri needs to be a valid storage URI.")
+		return 2
+	}
+	errs := storagetest.Check(context.Background(), s)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+	fmt.Println("ok")
+	return 0
+}
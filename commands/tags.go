@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/rs/zerolog/log"
+)
+
+// tagsCommand recomputes the tags table from the tag map.
+func tagsCommand(configFile string, args []string) int {
+	if len(args) != 1 || args[0] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "Usage: kittehbooru tags rebuild")
+		return 2
+	}
+
+	db := database.OpenDB(configFile)
+	n, err := db.RebuildTags(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("Rebuilding tags failed")
+		return 1
+	}
+	log.Info().Int("tags", n).Msg("Tags rebuilt")
+	return 0
+}
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrInvalidAlias is returned when a alias's tags aren't plain tags or are the same.
+	ErrInvalidAlias = errors.New("Aliases must be between two different plain tags")
+	// ErrAliasExists is returned when a tag already has a alias.
+	ErrAliasExists = errors.New("Tag already has a alias")
+	// ErrAliasChain is returned when approving a alias would alias a tag to
+	// a aliased tag, or alias a tag other tags are aliased to.
+	ErrAliasChain = errors.New("Aliases can't be chained")
+	// ErrAliasNotExist is returned when a alias does not exist.
+	ErrAliasNotExist = errors.New("Alias does not exist")
+)
+
+// plainTag checks a tag can be used in a alias or implication, which rules
+// out metatags, rating and user tags and wildcards.
+func plainTag(tag string) bool {
+	if tag == "" || tag != utils.FilterString(tag) || strings.ContainsAny(tag, " *") || strings.HasPrefix(tag, "-") {
+		return false
+	}
+	if _, _, ok := parseMetatag(tag); ok {
+		return false
+	}
+	for _, prefix := range []string{orderPrefix, ratingTagPrefix, "user:"} {
+		if strings.HasPrefix(tag, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyAlias replaces a search term's tag with the tag it is a alias of,
+// keeping the - of negated terms.
+func applyAlias(aliases map[string]string, tag string) string {
+	if strings.HasPrefix(tag, "-") {
+		if consequent, ok := aliases[tag[1:]]; ok {
+			return "-" + consequent
+		}
+	} else if consequent, ok := aliases[tag]; ok {
+		return consequent
+	}
+	return tag
+}
+
+// activeAliases returns the approved aliases by antecedent. They are cached
+// for a minute so every search doesn't have to fetch them.
+func (db *DB) activeAliases(ctx context.Context) map[string]string {
+	defer trace.StartRegion(ctx, "DB/activeAliases").End()
+
+	if val, ok := aliasCache.Get(ctx, "active"); ok {
+		return val.(map[string]string)
+	}
+	aliases := make(map[string]string)
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "antecedent", "consequent" FROM tag_aliases WHERE "status" = $1`, types.AliasActive)
+	if err != nil {
+		log.Error().Err(err).Msg("activeAliases can't query statement")
+		return aliases
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var antecedent, consequent string
+		if err := rows.Scan(&antecedent, &consequent); err != nil {
+			log.Error().Err(err).Msg("activeAliases can't scan row")
+			return aliases
+		}
+		aliases[antecedent] = consequent
+	}
+	aliasCache.Set(ctx, "active", aliases, 0)
+	return aliases
+}
+
+// TagAliases returns every alias, pending ones first.
+func (db *DB) TagAliases(ctx context.Context) ([]types.TagAlias, error) {
+	defer trace.StartRegion(ctx, "DB/TagAliases").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "antecedent", "consequent", "status", "creator", "approver", "timestamp" FROM tag_aliases ORDER BY "status" = $1 DESC, "antecedent" ASC`, types.AliasPending)
+	if err != nil {
+		log.Error().Err(err).Msg("TagAliases can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	aliases := make([]types.TagAlias, 0)
+	for rows.Next() {
+		var a types.TagAlias
+		if err := rows.Scan(&a.Antecedent, &a.Consequent, &a.Status, &a.Creator, &a.Approver, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// tagAlias fetches a alias by its antecedent.
+func (db *DB) tagAlias(ctx context.Context, antecedent string) (a types.TagAlias, err error) {
+	err = db.sqldb.QueryRowContext(ctx, `SELECT "antecedent", "consequent", "status", "creator", "approver", "timestamp" FROM tag_aliases WHERE "antecedent" = $1`, antecedent).
+		Scan(&a.Antecedent, &a.Consequent, &a.Status, &a.Creator, &a.Approver, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		err = ErrAliasNotExist
+	}
+	return
+}
+
+// ProposeAlias adds a pending alias from antecedent to consequent, which
+// isn't used until it is approved.
+func (db *DB) ProposeAlias(ctx context.Context, creator, antecedent, consequent string) error {
+	defer trace.StartRegion(ctx, "DB/ProposeAlias").End()
+
+	antecedent = strings.ToLower(strings.TrimSpace(antecedent))
+	consequent = strings.ToLower(strings.TrimSpace(consequent))
+	if !plainTag(antecedent) || !plainTag(consequent) || antecedent == consequent {
+		return ErrInvalidAlias
+	}
+	res, err := db.sqldb.ExecContext(ctx, `INSERT INTO tag_aliases("antecedent", "consequent", "status", "creator", "approver", "timestamp") VALUES ($1, $2, $3, $4, '', $5) ON CONFLICT DO NOTHING`,
+		antecedent, consequent, types.AliasPending, creator, nowMillis())
+	if err != nil {
+		log.Warn().Err(err).Msg("ProposeAlias can't execute insert statement")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAliasExists
+	}
+	return nil
+}
+
+// ApproveAlias makes a pending alias active and rewrites the tags of
+// posts with the antecedent to use the consequent.
+func (db *DB) ApproveAlias(ctx context.Context, approver, antecedent string) (int, error) {
+	defer trace.StartRegion(ctx, "DB/ApproveAlias").End()
+
+	a, err := db.tagAlias(ctx, antecedent)
+	if err != nil {
+		return 0, err
+	}
+	aliases := db.activeAliases(ctx)
+	if _, ok := aliases[a.Consequent]; ok {
+		return 0, ErrAliasChain
+	}
+	for _, consequent := range aliases {
+		if consequent == a.Antecedent {
+			return 0, ErrAliasChain
+		}
+	}
+
+	_, err = db.sqldb.ExecContext(ctx, `UPDATE tag_aliases SET "status" = $1, "approver" = $2 WHERE "antecedent" = $3`, types.AliasActive, approver, antecedent)
+	if err != nil {
+		log.Warn().Err(err).Msg("ApproveAlias can't execute update statement")
+		return 0, err
+	}
+	aliasCache.Flush(ctx)
+	return db.ApplyAlias(ctx, a.Antecedent, a.Consequent)
+}
+
+// RemoveAlias removes a alias. Posts which were already rewritten keep the consequent.
+func (db *DB) RemoveAlias(ctx context.Context, antecedent string) error {
+	defer trace.StartRegion(ctx, "DB/RemoveAlias").End()
+
+	_, err := db.sqldb.ExecContext(ctx, `DELETE FROM tag_aliases WHERE "antecedent" = $1`, antecedent)
+	if err != nil {
+		log.Warn().Err(err).Msg("RemoveAlias can't execute delete statement")
+		return err
+	}
+	aliasCache.Flush(ctx)
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// ApplyAlias rewrites the tag map and tags of every post with the
+// antecedent to have the consequent instead, returning how many posts
+// were changed. The tags the consequent implies are then added to those
+// posts, see QueueImplicationBackfill.
+func (db *DB) ApplyAlias(ctx context.Context, antecedent, consequent string) (int, error) {
+	defer trace.StartRegion(ctx, "DB/ApplyAlias").End()
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT posts.postid, posts.tags FROM posts JOIN "tagMap" ON "tagMap".postid = posts.postid WHERE "tagMap".tag = $1`, antecedent)
+	if err != nil {
+		log.Error().Err(err).Msg("ApplyAlias can't query posts")
+		return 0, err
+	}
+	newTags := make(map[int64]string)
+	for rows.Next() {
+		var postID int64
+		var tags string
+		if err := rows.Scan(&postID, &tags); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		replaced := make([]string, 0)
+		for _, tag := range utils.SplitTagsString(tags) {
+			if tag == antecedent {
+				tag = consequent
+			}
+			if !sliceContains(replaced, tag) {
+				replaced = append(replaced, tag)
+			}
+		}
+		newTags[postID] = utils.TagsListToString(replaced)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for postID, tags := range newTags {
+		if _, err = tx.ExecContext(ctx, `UPDATE posts SET tags = $1 WHERE postid = $2`, tags, postID); err != nil {
+			log.Warn().Err(err).Msg("ApplyAlias can't update post tags")
+			return 0, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM "tagMap" WHERE tag = $1 AND postid IN (SELECT postid FROM "tagMap" WHERE tag = $2)`, antecedent, consequent)
+	if err != nil {
+		log.Warn().Err(err).Msg("ApplyAlias can't delete duplicate tags")
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO tags("name", "category") VALUES ($1, $2) ON CONFLICT DO NOTHING`, consequent, db.TagCategory(ctx, consequent).Name)
+	if err != nil {
+		log.Warn().Err(err).Msg("ApplyAlias can't insert tag")
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE "tagMap" SET tag = $1, tagid = (SELECT "id" FROM tags WHERE "name" = $1) WHERE tag = $2`, consequent, antecedent)
+	if err != nil {
+		log.Warn().Err(err).Msg("ApplyAlias can't update tag map")
+		return 0, err
+	}
+	if err = recountTags(ctx, tx, antecedent, consequent); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	searchCache.Flush(ctx)
+	tagCountsCache.Flush(ctx)
+	autocompleteCache.Flush(ctx)
+	if len(newTags) != 0 {
+		err = db.QueueImplicationBackfill(ctx, consequent)
+	}
+	return len(newTags), err
+}
+
+// ApplyAliases rewrites posts for every active alias, for aliases approved
+// before posts were imported or while a rewrite failed.
+func (db *DB) ApplyAliases(ctx context.Context) (int, error) {
+	defer trace.StartRegion(ctx, "DB/ApplyAliases").End()
+
+	total := 0
+	for antecedent, consequent := range db.activeAliases(ctx) {
+		n, err := db.ApplyAlias(ctx, antecedent, consequent)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// AliasedTag returns the tag a tag is a alias of, or the tag itself if it isn't aliased.
+func (db *DB) AliasedTag(ctx context.Context, tag string) string {
+	return applyAlias(db.activeAliases(ctx), tag)
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"runtime/trace"
+	"strconv"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// maxAutocompleteTags is the most tags AutocompleteTags returns.
+const maxAutocompleteTags = 25
+
+// likeEscaper escapes the characters LIKE treats specially.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// AutocompleteTags returns up to limit tags starting with prefix, or whose
+// name after their namespace does such as user:prefix, most used first.
+// Results are cached as the same short prefixes are asked for by everyone
+// typing a tag.
+func (db *DB) AutocompleteTags(ctx context.Context, prefix string, limit int) ([]types.TagCounts, error) {
+	defer trace.StartRegion(ctx, "DB/AutocompleteTags").End()
+
+	prefix = utils.FilterString(prefix)
+	if prefix == "" {
+		return []types.TagCounts{}, nil
+	}
+	if limit <= 0 || limit > maxAutocompleteTags {
+		limit = maxAutocompleteTags
+	}
+	key := strconv.Itoa(limit) + " " + prefix
+	if val, ok := autocompleteCache.Get(ctx, key); ok {
+		return val.([]types.TagCounts), nil
+	}
+
+	// Both conditions can use the indexes on tags, the second matches the
+	// part of namespaced tags after the colon.
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "name", "post_count" FROM tags WHERE "post_count" > 0 AND ("name" LIKE $1 OR split_part("name", ':', 2) LIKE $1) ORDER BY "post_count" DESC, "name" ASC LIMIT $2`,
+		likeEscaper.Replace(prefix)+"%", limit)
+	if err != nil {
+		log.Error().Err(err).Msg("AutocompleteTags can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]types.TagCounts, 0, limit)
+	for rows.Next() {
+		var t types.TagCounts
+		if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	autocompleteCache.Set(ctx, key, tags, 0)
+	return tags, nil
+}
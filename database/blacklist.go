@@ -0,0 +1,66 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+)
+
+// cleanBlacklist removes empty, wildcard and negated tags, which can't be blacklisted.
+func cleanBlacklist(tags []string) []string {
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag != "" && tag != "*" && !strings.HasPrefix(tag, "-") {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned
+}
+
+// blacklistToString normalises a blacklist the same way as search terms,
+// so wildcard patterns are kept, for storing in the database.
+func blacklistToString(tags []string) string {
+	return searchKey(cleanBlacklist(normaliseSearchTags(tags)))
+}
+
+// blacklistFromString splits a blacklist stored in the database.
+func blacklistFromString(s string) []string {
+	return cleanBlacklist(utils.SplitTagsString(s))
+}
+
+// blacklistFilter adds a negated tag to a search for every tag in the
+// viewer's blacklist, unless the search asks for that tag.
+func blacklistFilter(viewer types.User, tags []string) []string {
+	for _, tag := range viewer.Blacklist {
+		if !sliceContains(tags, tag) {
+			tags = append(tags, "-"+tag)
+		}
+	}
+	return tags
+}
+
+// viewerFilter limits a search to posts the viewer wants to see by adding
+// negated tags for hidden ratings and the viewer's blacklist, and hides
+// child posts if the instance is set to. As the
+// negated tags become part of the search, results are cached per filter.
+// It returns false if the search can't match any posts the viewer may see.
+func (db *DB) viewerFilter(viewer types.User, tags []string) ([]string, bool) {
+	tags, ok := db.ratingFilter(viewer, tags)
+	if !ok {
+		return nil, false
+	}
+	return blacklistFilter(viewer, db.childFilter(tags)), true
+}
+
+// BlacklistedTags returns the tags and patterns in the viewer's blacklist
+// which a post matches.
+func (db *DB) BlacklistedTags(viewer types.User, post types.Post) []string {
+	matched := make([]string, 0)
+	for _, tag := range viewer.Blacklist {
+		if sliceContains(post.Tags, tag) || tag == ratingTagPrefix+post.Rating || (isWildcardPattern(tag) && anyGlobMatch(tag, post.Tags)) {
+			matched = append(matched, tag)
+		}
+	}
+	return matched
+}
@@ -32,8 +32,26 @@ func (c ContextCache) Delete(ctx context.Context, k string) {
 	c.c.Delete(k)
 }
 
+// DeleteFunc deletes every item with a key match returns true for.
+func (c ContextCache) DeleteFunc(ctx context.Context, match func(k string) bool) {
+	defer trace.StartRegion(ctx, c.name+"/DeleteFunc").End()
+	for k := range c.c.Items() {
+		if match(k) {
+			c.c.Delete(k)
+		}
+	}
+}
+
+func (c ContextCache) Flush(ctx context.Context) {
+	defer trace.StartRegion(ctx, c.name+"/Flush").End()
+	c.c.Flush()
+}
+
 var userCache = ContextCache{cache.New(time.Minute, time.Minute), "userCache"}
-var postTagsCache = ContextCache{cache.New(5*time.Minute, time.Minute), "postTagsCache"}
 var searchCache = ContextCache{cache.New(time.Minute, time.Minute/2), "searchCache"}
 var tagCountsCache = ContextCache{cache.New(5*time.Minute, time.Minute), "tagCountsCache"}
 var sessionCache = ContextCache{cache.New(time.Minute, time.Minute), "sessionCache"}
+var aliasCache = ContextCache{cache.New(time.Minute, time.Minute), "aliasCache"}
+var implicationCache = ContextCache{cache.New(time.Minute, time.Minute), "implicationCache"}
+var autocompleteCache = ContextCache{cache.New(5*time.Minute, time.Minute), "autocompleteCache"}
+var categoryCache = ContextCache{cache.New(time.Minute, time.Minute), "categoryCache"}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"runtime/trace"
+	"sort"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrInvalidCategory is returned when a category's name isn't usable as a
+	// namespace or its colour isn't a hex colour.
+	ErrInvalidCategory = errors.New("Categories must have a name of lowercase letters and underscores which isn't a metatag and a colour like #1a2b3c")
+	// ErrCategoryRequired is returned when removing the general or user category.
+	ErrCategoryRequired = errors.New("The general and user categories can't be removed")
+)
+
+var (
+	categoryNameRegex   = regexp.MustCompile(`^[a-z_]+$`)
+	categoryColourRegex = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+)
+
+// defaultCategories are the categories added when the table is created.
+var defaultCategories = []types.TagCategory{
+	{Name: "artist", Colour: "#c00004", Order: 0},
+	{Name: "copyright", Colour: "#a800aa", Order: 1},
+	{Name: "character", Colour: "#00ab2c", Order: 2},
+	{Name: "user", Colour: "#8a8a8a", Order: 3},
+	{Name: types.GeneralCategory, Colour: "#0075f8", Order: 4},
+	{Name: "meta", Colour: "#fd9200", Order: 5},
+}
+
+// seedTagCategories adds the default categories if there aren't any yet.
+func (db *DB) seedTagCategories() {
+	var count int64
+	if err := db.sqldb.QueryRow(`SELECT COUNT(*) FROM tag_categories`).Scan(&count); err != nil || count != 0 {
+		return
+	}
+	for _, c := range defaultCategories {
+		_, err := db.sqldb.Exec(`INSERT INTO tag_categories("name", "colour", "order") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, c.Name, c.Colour, c.Order)
+		if err != nil {
+			log.Warn().Err(err).Msg("SQL Seed Tag Categories")
+			return
+		}
+	}
+}
+
+// TagCategories returns every category in the order they are shown in.
+// They are cached for a minute as every tag list needs them.
+func (db *DB) TagCategories(ctx context.Context) []types.TagCategory {
+	defer trace.StartRegion(ctx, "DB/TagCategories").End()
+
+	if val, ok := categoryCache.Get(ctx, "all"); ok {
+		return val.([]types.TagCategory)
+	}
+	categories := make([]types.TagCategory, 0)
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "name", "colour", "order" FROM tag_categories ORDER BY "order" ASC, "name" ASC`)
+	if err != nil {
+		log.Error().Err(err).Msg("TagCategories can't query statement")
+		return categories
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c types.TagCategory
+		if err := rows.Scan(&c.Name, &c.Colour, &c.Order); err != nil {
+			log.Error().Err(err).Msg("TagCategories can't scan row")
+			return categories
+		}
+		categories = append(categories, c)
+	}
+	categoryCache.Set(ctx, "all", categories, 0)
+	return categories
+}
+
+// tagCategory finds the category of a tag from its namespace, tags
+// without one or with a unknown one are general.
+func tagCategory(categories []types.TagCategory, tag string) types.TagCategory {
+	name := types.GeneralCategory
+	if i := strings.Index(tag, ":"); i > 0 {
+		name = tag[:i]
+	}
+	general := types.TagCategory{Name: types.GeneralCategory}
+	for _, c := range categories {
+		if c.Name == name {
+			return c
+		}
+		if c.Name == types.GeneralCategory {
+			general = c
+		}
+	}
+	return general
+}
+
+// TagCategory returns the category of a tag.
+func (db *DB) TagCategory(ctx context.Context, tag string) types.TagCategory {
+	return tagCategory(db.TagCategories(ctx), tag)
+}
+
+// sortTagCounts sorts tag counts by category then by count, most used first.
+func (db *DB) sortTagCounts(ctx context.Context, tagCounts []types.TagCounts) {
+	categories := db.TagCategories(ctx)
+	sort.Slice(tagCounts, func(i, j int) bool {
+		ci := tagCategory(categories, tagCounts[i].Tag)
+		cj := tagCategory(categories, tagCounts[j].Tag)
+		if ci.Name != cj.Name {
+			if ci.Order != cj.Order {
+				return ci.Order < cj.Order
+			}
+			return ci.Name < cj.Name
+		}
+		if tagCounts[i].Count != tagCounts[j].Count {
+			return tagCounts[i].Count > tagCounts[j].Count
+		}
+		return tagCounts[i].Tag < tagCounts[j].Tag
+	})
+}
+
+// setTagCategories sets the category of every tag from its namespace, the
+// same way as tagCategory.
+func setTagCategories(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE tags SET "category" = COALESCE((SELECT "name" FROM tag_categories WHERE position(':' in tags."name") > 1 AND "name" = split_part(tags."name", ':', 1)), $1)`, types.GeneralCategory)
+	if err != nil {
+		log.Warn().Err(err).Msg("setTagCategories can't execute update statement")
+	}
+	return err
+}
+
+// SetTagCategory adds a category or changes the colour and order of one.
+func (db *DB) SetTagCategory(ctx context.Context, c types.TagCategory) error {
+	defer trace.StartRegion(ctx, "DB/SetTagCategory").End()
+
+	c.Name = strings.ToLower(strings.TrimSpace(c.Name))
+	c.Colour = strings.ToLower(strings.TrimSpace(c.Colour))
+	if !categoryNameRegex.MatchString(c.Name) || !categoryColourRegex.MatchString(c.Colour) {
+		return ErrInvalidCategory
+	}
+	if _, ok := metatags[c.Name]; ok || c.Name+":" == orderPrefix || c.Name+":" == ratingTagPrefix || c.Name+":" == parentTagPrefix {
+		return ErrInvalidCategory
+	}
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO tag_categories("name", "colour", "order") VALUES ($1, $2, $3) ON CONFLICT ("name") DO UPDATE SET "colour" = $2, "order" = $3`, c.Name, c.Colour, c.Order)
+	if err != nil {
+		log.Warn().Err(err).Msg("SetTagCategory can't execute insert statement")
+		return err
+	}
+	if err = setTagCategories(ctx, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	categoryCache.Flush(ctx)
+	tagCountsCache.Flush(ctx)
+	return nil
+}
+
+// RemoveTagCategory removes a category, tags in it become general tags.
+func (db *DB) RemoveTagCategory(ctx context.Context, name string) error {
+	defer trace.StartRegion(ctx, "DB/RemoveTagCategory").End()
+
+	if name == types.GeneralCategory || name == "user" {
+		return ErrCategoryRequired
+	}
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM tag_categories WHERE "name" = $1`, name)
+	if err != nil {
+		log.Warn().Err(err).Msg("RemoveTagCategory can't execute delete statement")
+		return err
+	}
+	if err = setTagCategories(ctx, tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	categoryCache.Flush(ctx)
+	tagCountsCache.Flush(ctx)
+	return nil
+}
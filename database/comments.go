@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// commentsPerPage is how many comments are in each page of comments.
+const commentsPerPage = 20
+
+// maxCommentLength is the longest a comment can be in bytes.
+const maxCommentLength = 10000
+
+var (
+	// ErrCommentNotExist is returned when a comment does not exist.
+	ErrCommentNotExist = errors.New("Comment does not exist")
+	// ErrInvalidComment is returned when a comment is empty or too long.
+	ErrInvalidComment = errors.New("Comments must not be empty or longer than 10000 characters")
+)
+
+// validComment checks a comment body isn't empty or too long.
+func validComment(body string) bool {
+	return len(strings.TrimSpace(body)) != 0 && len(body) <= maxCommentLength
+}
+
+// commentColumns are the columns scanned by scanComments.
+const commentColumns = `comments."id", comments."postid", comments."username", comments."body", comments."timestamp", comments."editedAt"`
+
+// scanComments reads comments from rows selecting commentColumns.
+func scanComments(rows *sql.Rows) ([]types.Comment, error) {
+	defer rows.Close()
+	comments := make([]types.Comment, 0)
+	for rows.Next() {
+		var c types.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.Username, &c.Body, &c.CreatedAt, &c.EditedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// AddComment adds a comment to a post, returning the comment's ID.
+func (db *DB) AddComment(ctx context.Context, username string, postID int64, body string) (id int64, err error) {
+	defer trace.StartRegion(ctx, "DB/AddComment").End()
+
+	if !validComment(body) {
+		return 0, ErrInvalidComment
+	}
+	if _, err = db.Post(ctx, postID); err != nil {
+		return 0, PostNotExistError
+	}
+	err = db.sqldb.QueryRowContext(ctx, `INSERT INTO comments("postid", "username", "body", "timestamp", "editedAt") VALUES ($1, $2, $3, $4, 0) RETURNING "id"`, postID, username, body, nowMillis()).Scan(&id)
+	if err != nil {
+		log.Warn().Err(err).Msg("AddComment can't execute insert statement")
+	}
+	return
+}
+
+// Comment fetches a comment from the database.
+func (db *DB) Comment(ctx context.Context, id int64) (c types.Comment, err error) {
+	defer trace.StartRegion(ctx, "DB/Comment").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE "id" = $1`, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Comment can't query statement")
+		return
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return
+	}
+	if len(comments) == 0 {
+		return c, ErrCommentNotExist
+	}
+	return comments[0], nil
+}
+
+// EditComment replaces the body of a comment.
+func (db *DB) EditComment(ctx context.Context, id int64, body string) error {
+	defer trace.StartRegion(ctx, "DB/EditComment").End()
+
+	if !validComment(body) {
+		return ErrInvalidComment
+	}
+	_, err := db.sqldb.ExecContext(ctx, `UPDATE comments SET "body" = $1, "editedAt" = $2 WHERE "id" = $3`, body, nowMillis(), id)
+	if err != nil {
+		log.Warn().Err(err).Msg("EditComment can't execute update statement")
+	}
+	return err
+}
+
+// DeleteComment deletes a comment.
+func (db *DB) DeleteComment(ctx context.Context, id int64) error {
+	defer trace.StartRegion(ctx, "DB/DeleteComment").End()
+
+	_, err := db.sqldb.ExecContext(ctx, `DELETE FROM comments WHERE "id" = $1`, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteComment can't execute delete statement")
+	}
+	return err
+}
+
+// PostComments returns a page of the comments on a post, oldest first,
+// along with how many pages of comments there are.
+func (db *DB) PostComments(ctx context.Context, postID int64, page int) ([]types.Comment, int, error) {
+	defer trace.StartRegion(ctx, "DB/PostComments").End()
+
+	var count int64
+	err := db.sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM comments WHERE "postid" = $1`, postID).Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("PostComments can't count comments")
+		return nil, 0, err
+	}
+	if page < 0 {
+		page = 0
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE "postid" = $1 ORDER BY "id" ASC LIMIT $2 OFFSET $3`, postID, commentsPerPage, page*commentsPerPage)
+	if err != nil {
+		log.Error().Err(err).Msg("PostComments can't query statement")
+		return nil, 0, err
+	}
+	comments, err := scanComments(rows)
+	return comments, countPages(count, commentsPerPage), err
+}
+
+// RecentComments returns a page of the newest comments on posts with
+// ratings the viewer sees, along with how many pages of comments there are.
+func (db *DB) RecentComments(ctx context.Context, viewer types.User, page int) ([]types.Comment, int, error) {
+	defer trace.StartRegion(ctx, "DB/RecentComments").End()
+
+	ratings := pq.Array(db.ViewerRatings(viewer))
+	var count int64
+	err := db.sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM comments JOIN posts ON posts.postid = comments.postid WHERE posts.rating = ANY($1)`, ratings).Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("RecentComments can't count comments")
+		return nil, 0, err
+	}
+	if page < 0 {
+		page = 0
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+commentColumns+` FROM comments JOIN posts ON posts.postid = comments.postid WHERE posts.rating = ANY($1) ORDER BY comments."id" DESC LIMIT $2 OFFSET $3`, ratings, commentsPerPage, page*commentsPerPage)
+	if err != nil {
+		log.Error().Err(err).Msg("RecentComments can't query statement")
+		return nil, 0, err
+	}
+	comments, err := scanComments(rows)
+	return comments, countPages(count, commentsPerPage), err
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/NamedKitten/kittehbooru/storage"
+	mirrorBackend "github.com/NamedKitten/kittehbooru/storage/backends/mirror"
 	"gopkg.in/yaml.v2"
 
 	"github.com/NamedKitten/kittehbooru/types"
@@ -47,12 +48,48 @@ type Settings struct {
 	ContentStorage string `yaml:"contentStorage"`
 	// Thumbnails Storage URI
 	ThumbnailsStorage string `yaml:"thumbnailsStorage"`
+	// EncryptionKeyFile is the path of the key used by encrypted+ storage URIs.
+	EncryptionKeyFile string `yaml:"encryptionKeyFile"`
+	// Content Storage Mirror URI, if set all content is also written here.
+	// Used to keep a new backend in sync while migrating to it.
+	ContentStorageMirror string `yaml:"contentStorageMirror"`
+	// Thumbnails Storage Mirror URI, if set all thumbnails are also written here.
+	ThumbnailsStorageMirror string `yaml:"thumbnailsStorageMirror"`
 	// Content URL
 	ContentURL string `yaml:"contentURL"`
 	// Thumbnail URL
 	ThumbnailURL string `yaml:"thumbnailURL"`
 	// Listen Address
 	ListenAddress string `yaml:"listenAddress"`
+	// SignedURLs requires content and thumbnail URLs to carry a signature
+	// and expiry time, so files can't be fetched by guessing post IDs.
+	SignedURLs bool `yaml:"signedURLs"`
+	// URLSigningKey is the secret used to sign URLs, generated if empty.
+	URLSigningKey string `yaml:"urlSigningKey"`
+	// SignedURLExpiry is how many seconds signed URLs are valid for.
+	SignedURLExpiry int64 `yaml:"signedURLExpiry"`
+	// StorageQuota is how many MiB of files each user can upload, 0 for no limit.
+	StorageQuota int64 `yaml:"storageQuota"`
+	// PostQuota is how many posts each user can have, 0 for no limit.
+	PostQuota int64 `yaml:"postQuota"`
+	// UploadsPerHour is how many posts each user can upload in a hour, 0 for no limit.
+	UploadsPerHour int64 `yaml:"uploadsPerHour"`
+	// DefaultRating is the rating of posts uploaded without one, "q" if empty.
+	DefaultRating string `yaml:"defaultRating"`
+	// DefaultRatings are the ratings shown to logged in users who haven't
+	// chosen which ratings to show, "s" and "q" if empty.
+	DefaultRatings []string `yaml:"defaultRatings"`
+	// AnonymousRatings are the only ratings shown to visitors who aren't
+	// logged in, "s" if empty.
+	AnonymousRatings []string `yaml:"anonymousRatings"`
+	// HideChildPosts hides posts with a parent from searches which don't
+	// ask about parents or children.
+	HideChildPosts bool `yaml:"hideChildPosts"`
+}
+
+// StorageOptions returns the options used to create the storage backends.
+func (s Settings) StorageOptions() storage.Options {
+	return storage.Options{KeyFile: s.EncryptionKeyFile}
 }
 
 // DB is the type at which all things are stored in the database.
@@ -65,6 +102,14 @@ type DB struct {
 	Settings          Settings      `yaml:"settings"`
 	ContentStorage    types.Storage `yaml:"-"`
 	ThumbnailsStorage types.Storage `yaml:"-"`
+	// thumbnailQueue is a queue of post IDs whose thumbnails need to be regenerated.
+	thumbnailQueue chan int64
+	// implicationQueue is a queue of tags whose implications need to be
+	// added to existing posts.
+	implicationQueue chan string
+	// workersStarted is true once the background workers which handle the
+	// queues are running.
+	workersStarted bool
 }
 
 // Save saves the settings.
@@ -80,13 +125,26 @@ func (db *DB) Save() {
 	}
 }
 
-// init creates all the database fields and starts cache, thumbnail and session management
+// init opens the storage backends and database and creates all the database fields.
 func (db *DB) init() {
 	snowflake.Epoch = 1551864242
 	var err error
 
-	db.ContentStorage = storage.GetStorage(db.Settings.ContentStorage)
-	db.ThumbnailsStorage = storage.GetStorage(db.Settings.ThumbnailsStorage)
+	db.thumbnailQueue = make(chan int64, 100)
+	db.implicationQueue = make(chan string, 100)
+
+	storageOpts := db.Settings.StorageOptions()
+	db.ContentStorage = storage.GetStorage(db.Settings.ContentStorage, storageOpts)
+	db.ThumbnailsStorage = storage.GetStorage(db.Settings.ThumbnailsStorage, storageOpts)
+	if db.Settings.ContentStorageMirror != "" {
+		db.ContentStorage = mirrorBackend.New(db.ContentStorage, storage.GetStorage(db.Settings.ContentStorageMirror, storageOpts))
+	}
+	if db.Settings.ThumbnailsStorageMirror != "" {
+		db.ThumbnailsStorage = mirrorBackend.New(db.ThumbnailsStorage, storage.GetStorage(db.Settings.ThumbnailsStorageMirror, storageOpts))
+	}
+	if db.ContentStorage == nil || db.ThumbnailsStorage == nil {
+		log.Fatal().Msg("Invalid storage settings")
+	}
 
 	db.sqldb, err = sql.Open(db.Settings.DatabaseType, db.Settings.DatabaseURI)
 	if err != nil {
@@ -96,8 +154,9 @@ func (db *DB) init() {
 
 	db.sqlInit()
 
-	if !db.SetupCompleted {
-		log.Warn().Msg("You need to go to /setup in web browser to setup this imageboard.")
+	if err = db.initURLSigningKey(); err != nil {
+		log.Error().Err(err).Msg("Can't generate URL signing key")
+		panic(err)
 	}
 	if db.Settings.ReCaptcha {
 		captcha, err = recaptcha.NewReCAPTCHA(db.Settings.ReCaptchaPrivkey, recaptcha.V3, 10*time.Second)
@@ -106,12 +165,57 @@ func (db *DB) init() {
 			panic(err)
 		}
 	}
+}
+
+// startWorkers starts the background thumbnail and session management.
+func (db *DB) startWorkers() {
+	if !db.SetupCompleted {
+		log.Warn().Msg("You need to go to /setup in web browser to setup this imageboard.")
+	}
 	go db.thumbnailScanner()
+	go db.thumbnailWorker()
 	go db.sessionCleaner()
+	go db.backfillPostSizes()
+	go db.implicationWorker()
+	db.workersStarted = true
 }
 
 // LoadDB loads the settings file and initializes the database
 func LoadDB(configFile string) *DB {
+	db := readSettings(configFile)
+	db.init()
+	db.startWorkers()
+	db.Save()
+	return db
+}
+
+// OpenDB loads the settings file and initializes the database without
+// starting any background workers, for use by command line tools.
+func OpenDB(configFile string) *DB {
+	db := readSettings(configFile)
+	db.init()
+	return db
+}
+
+// NewDB returns a DB using a database which is already open, without
+// creating the tables, opening storage or starting any background workers.
+func NewDB(sqldb *sql.DB, settings Settings) *DB {
+	return &DB{
+		sqldb:            sqldb,
+		SetupCompleted:   true,
+		Settings:         settings,
+		thumbnailQueue:   make(chan int64, 100),
+		implicationQueue: make(chan string, 100),
+	}
+}
+
+// ReadSettings reads the settings file without connecting to the database.
+func ReadSettings(configFile string) Settings {
+	return readSettings(configFile).Settings
+}
+
+// readSettings reads the settings file into a new DB.
+func readSettings(configFile string) *DB {
 	db := &DB{}
 	_, err := os.Stat(configFile)
 	if err != nil {
@@ -131,7 +235,5 @@ func LoadDB(configFile string) *DB {
 		db = &DB{}
 	}
 	db.configFile = configFile
-	db.init()
-	db.Save()
 	return db
 }
@@ -0,0 +1,362 @@
+package database
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// exportVersion is the version of the archive format written by Export.
+const exportVersion = 1
+
+// exportTable describes a table which is included in an instance export.
+type exportTable struct {
+	// Name of the table.
+	Name string
+	// Serial is the name of a SERIAL column whose sequence has to be
+	// updated after restoring, if there is one.
+	Serial string
+}
+
+// exportTables are the tables written to an export archive, in the order
+// they need to be restored in.
+var exportTables = []exportTable{
+	{Name: "users"},
+	{Name: "posts"},
+	{Name: "tags", Serial: "id"},
+	{Name: "tagMap", Serial: "id"},
+	{Name: "favorites"},
+	{Name: "votes"},
+	{Name: "comments", Serial: "id"},
+	{Name: "notes", Serial: "id"},
+	{Name: "note_versions"},
+	{Name: "pools", Serial: "id"},
+	{Name: "pool_posts"},
+	{Name: "tag_aliases"},
+	{Name: "tag_implications"},
+	{Name: "tag_categories"},
+	{Name: "wiki_pages"},
+	{Name: "wiki_revisions"},
+	{Name: "mass_tag_edits", Serial: "id"},
+	{Name: "mass_tag_edit_posts"},
+}
+
+// passwordsTable is only exported when ExportOptions.Passwords is set.
+var passwordsTable = exportTable{Name: "passwords"}
+
+// ErrRestoreNotEmpty is returned by Restore when the instance already has posts or users.
+var ErrRestoreNotEmpty = errors.New("Instance is not empty")
+
+// ExportOptions controls what is written by Export.
+type ExportOptions struct {
+	// Passwords includes the password hashes of all users and the secrets
+	// in the settings.
+	Passwords bool
+}
+
+// exportManifest is written as manifest.json in every export archive.
+type exportManifest struct {
+	Version   int   `json:"version"`
+	CreatedAt int64 `json:"createdAt"`
+	Passwords bool  `json:"passwords"`
+}
+
+// Export writes a gzipped tar archive containing the settings, all database
+// tables and all content files of this instance to w.
+func (db *DB) Export(ctx context.Context, w io.Writer, opts ExportOptions) (err error) {
+	defer trace.StartRegion(ctx, "DB/Export").End()
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest := exportManifest{
+		Version:   exportVersion,
+		CreatedAt: time.Now().Unix(),
+		Passwords: opts.Passwords,
+	}
+	if err = writeTarJSON(tw, "manifest.json", manifest); err != nil {
+		return
+	}
+	if err = writeTarJSON(tw, "settings.json", db.exportSettings(opts)); err != nil {
+		return
+	}
+
+	tables := exportTables
+	if opts.Passwords {
+		tables = append(tables[:len(tables):len(tables)], passwordsTable)
+	}
+	for _, table := range tables {
+		if err = db.exportTable(ctx, tw, table); err != nil {
+			log.Error().Err(err).Str("table", table.Name).Msg("Export can't export table")
+			return
+		}
+	}
+
+	if err = db.exportContent(ctx, tw); err != nil {
+		return
+	}
+
+	if err = tw.Close(); err != nil {
+		return
+	}
+	return gw.Close()
+}
+
+// exportSettings returns the settings written to a archive, leaving out
+// secrets unless password hashes are included too.
+func (db *DB) exportSettings(opts ExportOptions) Settings {
+	s := db.Settings
+	if !opts.Passwords {
+		s.DatabaseURI = ""
+		s.ReCaptchaPrivkey = ""
+		s.URLSigningKey = ""
+	}
+	return s
+}
+
+// exportTable writes all rows of a table as a JSON array to tables/<name>.json.
+func (db *DB) exportTable(ctx context.Context, tw *tar.Writer, table exportTable) error {
+	rows, err := db.sqldb.QueryContext(ctx, fmt.Sprintf(`SELECT row_to_json(t) FROM "%s" t`, table.Name))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	data := make([]json.RawMessage, 0)
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		data = append(data, json.RawMessage(row))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writeTarJSON(tw, "tables/"+table.Name+".json", data)
+}
+
+// exportContent writes every post's content file to content/<filename>.
+func (db *DB) exportContent(ctx context.Context, tw *tar.Writer) error {
+	posts, err := db.postFiles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		name := fmt.Sprintf("%s.%s", p.Filename, p.FileExtension)
+		if err := db.exportContentFile(tw, name); err != nil {
+			log.Error().Err(err).Str("file", name).Msg("Export can't export content file")
+			return err
+		}
+	}
+	return nil
+}
+
+// exportContentFile copies a single file from ContentStorage into the archive.
+func (db *DB) exportContentFile(tw *tar.Writer, name string) error {
+	f, err := db.ContentStorage.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    "content/" + name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// writeTarJSON writes v encoded as JSON as a file called name to the archive.
+func writeTarJSON(tw *tar.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Restore rebuilds an empty instance from an archive created by Export.
+// Settings which are specific to where the instance runs, such as the database
+// and storage URIs, are kept from the current settings so an archive can be
+// restored onto a different storage backend.
+func (db *DB) Restore(ctx context.Context, r io.Reader) error {
+	defer trace.StartRegion(ctx, "DB/Restore").End()
+
+	var count int
+	err := db.sqldb.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM posts) + (SELECT COUNT(*) FROM users)`).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return ErrRestoreNotEmpty
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	tables := make(map[string][]json.RawMessage)
+	var settings Settings
+	var manifest exportManifest
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == "manifest.json":
+			err = json.NewDecoder(tr).Decode(&manifest)
+		case name == "settings.json":
+			err = json.NewDecoder(tr).Decode(&settings)
+		case strings.HasPrefix(name, "tables/"):
+			var rows []json.RawMessage
+			err = json.NewDecoder(tr).Decode(&rows)
+			tables[strings.TrimSuffix(strings.TrimPrefix(name, "tables/"), ".json")] = rows
+		case strings.HasPrefix(name, "content/"):
+			err = db.restoreContentFile(ctx, strings.TrimPrefix(name, "content/"), tr)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("file", name).Msg("Restore can't read archive entry")
+			return err
+		}
+	}
+
+	if manifest.Version != exportVersion {
+		return fmt.Errorf("Unsupported export version %d", manifest.Version)
+	}
+	if !manifest.Passwords {
+		log.Warn().Msg("Archive does not contain passwords, users will not be able to log in.")
+	}
+
+	if err := db.restoreTables(ctx, tables); err != nil {
+		return err
+	}
+	// Archives from before the tags table don't have it, so it is rebuilt
+	// from the tag map.
+	if _, err := db.RebuildTags(ctx); err != nil {
+		return err
+	}
+
+	db.restoreSettings(settings)
+	db.SetupCompleted = true
+	db.Save()
+	return nil
+}
+
+// restoreContentFile writes a file from the archive to ContentStorage.
+func (db *DB) restoreContentFile(ctx context.Context, name string, r io.Reader) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid content filename %q", name)
+	}
+	f, err := db.ContentStorage.WriteFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		storage.Abort(f)
+		return err
+	}
+	return f.Close()
+}
+
+// restoreTables inserts all the rows of the exported tables in a single transaction.
+func (db *DB) restoreTables(ctx context.Context, tables map[string][]json.RawMessage) error {
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range append(exportTables[:len(exportTables):len(exportTables)], passwordsTable) {
+		for _, row := range tables[table.Name] {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(row, &fields); err != nil {
+				return err
+			}
+			// Only insert the columns present in the archive so
+			// any newer columns get their default values.
+			columns := make([]string, 0, len(fields))
+			for column := range fields {
+				columns = append(columns, `"`+strings.Replace(column, `"`, `""`, -1)+`"`)
+			}
+			sort.Strings(columns)
+			columnsStr := strings.Join(columns, ",")
+			s := fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM json_populate_record(NULL::"%s", $1)`, table.Name, columnsStr, columnsStr, table.Name)
+			if _, err := tx.ExecContext(ctx, s, string(row)); err != nil {
+				log.Error().Err(err).Str("table", table.Name).Msg("Restore can't insert row")
+				return err
+			}
+		}
+		if table.Serial != "" && len(tables[table.Name]) != 0 {
+			s := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('"%s"', '%s'), (SELECT MAX("%s") FROM "%s"))`, table.Name, table.Serial, table.Serial, table.Name)
+			if _, err := tx.ExecContext(ctx, s); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreSettings replaces the current settings with restored ones, keeping
+// the settings which describe where this instance runs.
+func (db *DB) restoreSettings(s Settings) {
+	s.DatabaseURI = db.Settings.DatabaseURI
+	s.DatabaseType = db.Settings.DatabaseType
+	s.ContentStorage = db.Settings.ContentStorage
+	s.ThumbnailsStorage = db.Settings.ThumbnailsStorage
+	s.ContentURL = db.Settings.ContentURL
+	s.ThumbnailURL = db.Settings.ThumbnailURL
+	s.ListenAddress = db.Settings.ListenAddress
+	s.ContentStorageMirror = db.Settings.ContentStorageMirror
+	s.ThumbnailsStorageMirror = db.Settings.ThumbnailsStorageMirror
+	s.EncryptionKeyFile = db.Settings.EncryptionKeyFile
+	// Archives without secrets keep this instance's.
+	if s.ReCaptchaPrivkey == "" {
+		s.ReCaptchaPrivkey = db.Settings.ReCaptchaPrivkey
+	}
+	s.SignedURLs = db.Settings.SignedURLs
+	s.URLSigningKey = db.Settings.URLSigningKey
+	s.SignedURLExpiry = db.Settings.SignedURLExpiry
+	db.Settings = s
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"runtime/trace"
+
+	"github.com/rs/zerolog/log"
+)
+
+// favMetatag matches posts favorited by a user with fav:username.
+func favMetatag(q *searchQuery, username string) string {
+	return `postid IN (SELECT postid FROM favorites WHERE username = ` + q.arg(username) + `)`
+}
+
+// AddFavorite adds a post to a user's favorites, doing nothing if it already is one.
+func (db *DB) AddFavorite(ctx context.Context, username string, postID int64) error {
+	defer trace.StartRegion(ctx, "DB/AddFavorite").End()
+
+	if _, err := db.Post(ctx, postID); err != nil {
+		return PostNotExistError
+	}
+	_, err := db.sqldb.ExecContext(ctx, `INSERT INTO favorites("username", "postid", "timestamp") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, username, postID, nowMillis())
+	if err != nil {
+		log.Warn().Err(err).Msg("AddFavorite can't execute insert statement")
+		return err
+	}
+	forgetSearches(ctx, "fav:"+username)
+	return nil
+}
+
+// RemoveFavorite removes a post from a user's favorites.
+func (db *DB) RemoveFavorite(ctx context.Context, username string, postID int64) error {
+	defer trace.StartRegion(ctx, "DB/RemoveFavorite").End()
+
+	_, err := db.sqldb.ExecContext(ctx, `DELETE FROM favorites WHERE username = $1 AND postid = $2`, username, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("RemoveFavorite can't execute delete statement")
+		return err
+	}
+	forgetSearches(ctx, "fav:"+username)
+	return nil
+}
+
+// IsFavorite checks if a post is one of a user's favorites.
+func (db *DB) IsFavorite(ctx context.Context, username string, postID int64) (fav bool, err error) {
+	defer trace.StartRegion(ctx, "DB/IsFavorite").End()
+
+	err = db.sqldb.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM favorites WHERE username = $1 AND postid = $2)`, username, postID).Scan(&fav)
+	if err != nil {
+		log.Error().Err(err).Msg("IsFavorite can't query statement")
+	}
+	return
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"strings"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// FsckReport lists the problems found by Fsck.
+type FsckReport struct {
+	// MissingContent are posts whose content file does not exist.
+	MissingContent []int64
+	// UnreadableContent are posts whose content file can't be read.
+	UnreadableContent []int64
+	// EmptyContent are posts whose content file is zero bytes.
+	EmptyContent []int64
+	// MissingThumbnails are posts without a thumbnail.
+	MissingThumbnails []int64
+	// EmptyThumbnails are posts whose thumbnail is zero bytes.
+	EmptyThumbnails []int64
+	// OrphanContent are content files which don't belong to any post.
+	OrphanContent []string
+	// OrphanThumbnails are thumbnails which don't belong to any post.
+	OrphanThumbnails []string
+}
+
+// OK returns true if no problems were found.
+func (r FsckReport) OK() bool {
+	return len(r.MissingContent) == 0 && len(r.UnreadableContent) == 0 &&
+		len(r.EmptyContent) == 0 && len(r.MissingThumbnails) == 0 &&
+		len(r.EmptyThumbnails) == 0 && len(r.OrphanContent) == 0 &&
+		len(r.OrphanThumbnails) == 0
+}
+
+// BrokenThumbnails returns the posts whose thumbnail needs to be regenerated.
+func (r FsckReport) BrokenThumbnails() []int64 {
+	return append(append([]int64{}, r.MissingThumbnails...), r.EmptyThumbnails...)
+}
+
+// orphanGracePeriod is how long a file has to be unchanged before Fsck
+// reports it as not belonging to a post, as uploads are written to storage
+// before their post is added.
+const orphanGracePeriod = time.Hour
+
+// fileState is the result of checking a single file.
+type fileState int
+
+const (
+	fileOK fileState = iota
+	fileMissing
+	fileUnreadable
+	fileEmpty
+)
+
+// checkFile checks that a file exists, is readable and isn't empty.
+func checkFile(s types.Storage, name string) fileState {
+	f, err := s.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileMissing
+		}
+		return fileUnreadable
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fileUnreadable
+	}
+	if info.Size() == 0 {
+		return fileEmpty
+	}
+	if _, err := f.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return fileUnreadable
+	}
+	return fileOK
+}
+
+// orphanable checks if a file which doesn't belong to a post was last
+// changed before the orphan grace period, so it isn't still being uploaded.
+// Files which can't be checked are left alone.
+func orphanable(s types.Storage, name string, now time.Time) bool {
+	f, err := s.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && now.Sub(info.ModTime()) >= orphanGracePeriod
+}
+
+// Fsck checks that every post has a readable content file and thumbnail and
+// finds files in storage which don't belong to any post.
+func (db *DB) Fsck(ctx context.Context) (report FsckReport, err error) {
+	defer trace.StartRegion(ctx, "DB/Fsck").End()
+
+	// Files are listed before reading the posts so the post of any file
+	// which was uploaded in between is found.
+	now := time.Now()
+	contentFiles, err := db.ContentStorage.List(ctx)
+	if err != nil {
+		return
+	}
+	thumbnailFiles, err := db.ThumbnailsStorage.List(ctx)
+	if err != nil {
+		return
+	}
+	posts, err := db.postFiles(ctx)
+	if err != nil {
+		return
+	}
+
+	contentNames := make(map[string]bool)
+	thumbnailNames := make(map[string]bool)
+
+	for _, p := range posts {
+		contentName := fmt.Sprintf("%s.%s", p.Filename, p.FileExtension)
+		thumbnailName := fmt.Sprintf("%d.webp", p.PostID)
+		contentNames[contentName] = true
+		thumbnailNames[thumbnailName] = true
+
+		switch checkFile(db.ContentStorage, contentName) {
+		case fileMissing:
+			report.MissingContent = append(report.MissingContent, p.PostID)
+		case fileUnreadable:
+			report.UnreadableContent = append(report.UnreadableContent, p.PostID)
+		case fileEmpty:
+			report.EmptyContent = append(report.EmptyContent, p.PostID)
+		}
+
+		switch checkFile(db.ThumbnailsStorage, thumbnailName) {
+		case fileMissing, fileUnreadable:
+			report.MissingThumbnails = append(report.MissingThumbnails, p.PostID)
+		case fileEmpty:
+			report.EmptyThumbnails = append(report.EmptyThumbnails, p.PostID)
+		}
+	}
+
+	for _, name := range contentFiles {
+		if !contentNames[name] && orphanable(db.ContentStorage, name, now) {
+			report.OrphanContent = append(report.OrphanContent, name)
+		}
+	}
+	for _, name := range thumbnailFiles {
+		if !thumbnailNames[name] && orphanable(db.ThumbnailsStorage, name, now) {
+			report.OrphanThumbnails = append(report.OrphanThumbnails, name)
+		}
+	}
+	return
+}
+
+// DeleteFiles deletes files from a storage, returning the first error.
+func (db *DB) DeleteFiles(ctx context.Context, s types.Storage, names []string) (err error) {
+	defer trace.StartRegion(ctx, "DB/DeleteFiles").End()
+	for _, name := range names {
+		if derr := s.Delete(name); derr != nil {
+			log.Error().Err(derr).Str("file", name).Msg("DeleteFiles can't delete file")
+			if err == nil {
+				err = derr
+			}
+		}
+	}
+	return
+}
+
+// QuarantineFiles moves files from a storage into a local directory.
+func (db *DB) QuarantineFiles(ctx context.Context, s types.Storage, names []string, dir string) error {
+	defer trace.StartRegion(ctx, "DB/QuarantineFiles").End()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("Invalid filename %q", name)
+		}
+		if err := quarantineFile(ctx, s, name, filepath.Join(dir, name)); err != nil {
+			log.Error().Err(err).Str("file", name).Msg("QuarantineFiles can't quarantine file")
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantineFile copies a file to dest and deletes it from the storage once copied.
+func quarantineFile(ctx context.Context, s types.Storage, name string, dest string) error {
+	src, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return s.Delete(name)
+}
+
+// RegenerateThumbnails deletes and recreates the thumbnails of posts.
+func (db *DB) RegenerateThumbnails(ctx context.Context, postIDs []int64) {
+	defer trace.StartRegion(ctx, "DB/RegenerateThumbnails").End()
+	for _, postID := range postIDs {
+		p, err := db.Post(ctx, postID)
+		if err != nil {
+			continue
+		}
+		db.ThumbnailsStorage.Delete(fmt.Sprintf("%d.webp", postID))
+		db.CreateThumbnail(ctx, p)
+	}
+}
+
+// QueueThumbnails queues posts to have their thumbnails regenerated in the background.
+func (db *DB) QueueThumbnails(postIDs []int64) {
+	go func() {
+		for _, postID := range postIDs {
+			db.thumbnailQueue <- postID
+		}
+	}()
+}
+
+// thumbnailWorker regenerates thumbnails queued by QueueThumbnails.
+func (db *DB) thumbnailWorker() {
+	for postID := range db.thumbnailQueue {
+		ctx, task := trace.NewTask(context.Background(), "thumbnailWorker")
+		db.RegenerateThumbnails(ctx, []int64{postID})
+		task.End()
+	}
+}
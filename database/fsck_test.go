@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fileBackend "github.com/NamedKitten/kittehbooru/storage/backends/file"
+)
+
+func TestOrphanable(t *testing.T) {
+	dir := t.TempDir()
+	fb := fileBackend.NewWithLayout(dir+"/", fileBackend.LayoutFlat)
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"new.png": 0, "recent.png": orphanGracePeriod - time.Minute, "old.png": orphanGracePeriod + time.Minute} {
+		w, err := fb.WriteFile(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(filepath.Join(dir, name), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"new.png", false},
+		{"recent.png", false},
+		{"old.png", true},
+		{"missing.png", false},
+	}
+	for _, test := range tests {
+		if got := orphanable(fb, test.name, now); got != test.want {
+			t.Errorf("orphanable(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
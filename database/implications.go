@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrInvalidImplication is returned when a implication's tags aren't plain tags or are the same.
+	ErrInvalidImplication = errors.New("Implications must be between two different plain tags")
+	// ErrImplicationCycle is returned when a implication would make a tag imply itself.
+	ErrImplicationCycle = errors.New("Implication would make a tag imply itself")
+)
+
+// implications returns the consequents of every implication by antecedent.
+// They are cached for a minute so every upload doesn't have to fetch them.
+func (db *DB) implications(ctx context.Context) map[string][]string {
+	defer trace.StartRegion(ctx, "DB/implications").End()
+
+	if val, ok := implicationCache.Get(ctx, "all"); ok {
+		return val.(map[string][]string)
+	}
+	graph := make(map[string][]string)
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "antecedent", "consequent" FROM tag_implications`)
+	if err != nil {
+		log.Error().Err(err).Msg("implications can't query statement")
+		return graph
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var antecedent, consequent string
+		if err := rows.Scan(&antecedent, &consequent); err != nil {
+			log.Error().Err(err).Msg("implications can't scan row")
+			return graph
+		}
+		graph[antecedent] = append(graph[antecedent], consequent)
+	}
+	implicationCache.Set(ctx, "all", graph, 0)
+	return graph
+}
+
+// impliedTags returns every tag a tag implies, following implications of
+// implied tags too. The seen map stops it looping if there is a cycle.
+func impliedTags(graph map[string][]string, tag string) []string {
+	implied := make([]string, 0)
+	seen := map[string]bool{tag: true}
+	queue := []string{tag}
+	for len(queue) != 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, c := range graph[t] {
+			if !seen[c] {
+				seen[c] = true
+				implied = append(implied, c)
+				queue = append(queue, c)
+			}
+		}
+	}
+	return implied
+}
+
+// addImplications adds the tags implied by a post's tags to them.
+func (db *DB) addImplications(ctx context.Context, tags []string) []string {
+	graph := db.implications(ctx)
+	if len(graph) == 0 {
+		return tags
+	}
+	for _, tag := range tags {
+		for _, implied := range impliedTags(graph, tag) {
+			if !sliceContains(tags, implied) {
+				tags = append(tags, implied)
+			}
+		}
+	}
+	return tags
+}
+
+// ImpliedBy returns which of a post's tags are implied by its other tags,
+// mapped to a tag which implies them.
+func (db *DB) ImpliedBy(ctx context.Context, tags []string) map[string]string {
+	defer trace.StartRegion(ctx, "DB/ImpliedBy").End()
+
+	graph := db.implications(ctx)
+	impliedBy := make(map[string]string)
+	for _, tag := range tags {
+		for _, implied := range impliedTags(graph, tag) {
+			if _, ok := impliedBy[implied]; !ok && sliceContains(tags, implied) {
+				impliedBy[implied] = tag
+			}
+		}
+	}
+	return impliedBy
+}
+
+// TagImplications returns every implication.
+func (db *DB) TagImplications(ctx context.Context) ([]types.TagImplication, error) {
+	defer trace.StartRegion(ctx, "DB/TagImplications").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "antecedent", "consequent", "creator", "timestamp" FROM tag_implications ORDER BY "antecedent" ASC, "consequent" ASC`)
+	if err != nil {
+		log.Error().Err(err).Msg("TagImplications can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	implications := make([]types.TagImplication, 0)
+	for rows.Next() {
+		var i types.TagImplication
+		if err := rows.Scan(&i.Antecedent, &i.Consequent, &i.Creator, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		implications = append(implications, i)
+	}
+	return implications, rows.Err()
+}
+
+// AddImplication adds a implication from antecedent to consequent and
+// queues adding the consequent to existing posts with the antecedent.
+func (db *DB) AddImplication(ctx context.Context, creator, antecedent, consequent string) error {
+	defer trace.StartRegion(ctx, "DB/AddImplication").End()
+
+	aliases := db.activeAliases(ctx)
+	antecedent = applyAlias(aliases, strings.ToLower(strings.TrimSpace(antecedent)))
+	consequent = applyAlias(aliases, strings.ToLower(strings.TrimSpace(consequent)))
+	if !plainTag(antecedent) || !plainTag(consequent) || antecedent == consequent {
+		return ErrInvalidImplication
+	}
+	if sliceContains(impliedTags(db.implications(ctx), consequent), antecedent) {
+		return ErrImplicationCycle
+	}
+
+	_, err := db.sqldb.ExecContext(ctx, `INSERT INTO tag_implications("antecedent", "consequent", "creator", "timestamp") VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, antecedent, consequent, creator, nowMillis())
+	if err != nil {
+		log.Warn().Err(err).Msg("AddImplication can't execute insert statement")
+		return err
+	}
+	implicationCache.Flush(ctx)
+	return db.QueueImplicationBackfill(ctx, antecedent)
+}
+
+// RemoveImplication removes a implication. Posts keep tags it already added.
+func (db *DB) RemoveImplication(ctx context.Context, antecedent, consequent string) error {
+	defer trace.StartRegion(ctx, "DB/RemoveImplication").End()
+
+	_, err := db.sqldb.ExecContext(ctx, `DELETE FROM tag_implications WHERE "antecedent" = $1 AND "consequent" = $2`, antecedent, consequent)
+	if err != nil {
+		log.Warn().Err(err).Msg("RemoveImplication can't execute delete statement")
+		return err
+	}
+	implicationCache.Flush(ctx)
+	return nil
+}
+
+// BackfillImplication adds the tags implied by a tag to every post with
+// it, returning how many posts were changed.
+func (db *DB) BackfillImplication(ctx context.Context, tag string) (int, error) {
+	defer trace.StartRegion(ctx, "DB/BackfillImplication").End()
+
+	implied := impliedTags(db.implications(ctx), tag)
+	if len(implied) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT posts.postid, posts.tags FROM posts JOIN "tagMap" ON "tagMap".postid = posts.postid WHERE "tagMap".tag = $1`, tag)
+	if err != nil {
+		log.Error().Err(err).Msg("BackfillImplication can't query posts")
+		return 0, err
+	}
+	missing := make(map[int64][]string)
+	newTags := make(map[int64]string)
+	for rows.Next() {
+		var postID int64
+		var tags string
+		if err := rows.Scan(&postID, &tags); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		postTags := utils.SplitTagsString(tags)
+		for _, t := range implied {
+			if !sliceContains(postTags, t) {
+				missing[postID] = append(missing[postID], t)
+				postTags = append(postTags, t)
+			}
+		}
+		if len(missing[postID]) != 0 {
+			newTags[postID] = utils.TagsListToString(postTags)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	categories := db.TagCategories(ctx)
+	for postID, tags := range newTags {
+		if _, err = tx.ExecContext(ctx, `UPDATE posts SET tags = $1 WHERE postid = $2`, tags, postID); err != nil {
+			log.Warn().Err(err).Msg("BackfillImplication can't update post tags")
+			return 0, err
+		}
+		for _, t := range missing[postID] {
+			if err = addPostTag(ctx, tx, categories, postID, t); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(newTags) != 0 {
+		searchCache.Flush(ctx)
+		tagCountsCache.Flush(ctx)
+	}
+	return len(newTags), nil
+}
+
+// BackfillImplications adds implied tags to existing posts for every implication.
+func (db *DB) BackfillImplications(ctx context.Context) (int, error) {
+	defer trace.StartRegion(ctx, "DB/BackfillImplications").End()
+
+	total := 0
+	for antecedent := range db.implications(ctx) {
+		n, err := db.BackfillImplication(ctx, antecedent)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// QueueImplicationBackfill queues adding the tags a tag implies to existing
+// posts, which is done in the background as it can change many posts.
+// Without the background workers, such as from the command line, it is
+// done straight away instead.
+func (db *DB) QueueImplicationBackfill(ctx context.Context, tag string) error {
+	if !db.workersStarted {
+		_, err := db.BackfillImplication(ctx, tag)
+		return err
+	}
+	go func() {
+		db.implicationQueue <- tag
+	}()
+	return nil
+}
+
+// implicationWorker backfills implications queued by QueueImplicationBackfill.
+func (db *DB) implicationWorker() {
+	for tag := range db.implicationQueue {
+		ctx, task := trace.NewTask(context.Background(), "implicationWorker")
+		n, err := db.BackfillImplication(ctx, tag)
+		if err != nil {
+			log.Error().Err(err).Str("tag", tag).Msg("Can't backfill implication")
+		} else {
+			log.Info().Str("tag", tag).Int("posts", n).Msg("Backfilled implication")
+		}
+		task.End()
+	}
+}
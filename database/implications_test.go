@@ -0,0 +1,38 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestImpliedTags(t *testing.T) {
+	graph := map[string][]string{
+		"kitten": {"cat"},
+		"cat":    {"animal", "feline"},
+		"feline": {"animal"},
+		// a and b imply each other and c.
+		"a": {"b"},
+		"b": {"a", "c"},
+		"d": {"d"},
+	}
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"dog", []string{}},
+		{"animal", []string{}},
+		{"cat", []string{"animal", "feline"}},
+		{"kitten", []string{"animal", "cat", "feline"}},
+		{"a", []string{"b", "c"}},
+		{"b", []string{"a", "c"}},
+		{"d", []string{}},
+	}
+	for _, test := range tests {
+		got := impliedTags(graph, test.tag)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("impliedTags(%q) = %q, want %q", test.tag, got, test.want)
+		}
+	}
+}
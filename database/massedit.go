@@ -0,0 +1,410 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// massEditsPerPage is how many mass tag edits are in each page of the log.
+const massEditsPerPage = 50
+
+// massEditBatchSize is how many posts are edited in each transaction of a
+// mass tag edit, so a large edit doesn't hold locks on every post at once.
+const massEditBatchSize = 100
+
+// massEditPreviewPosts is how many of the posts a mass tag edit would
+// change are returned by PreviewMassTagEdit.
+const massEditPreviewPosts = 20
+
+var (
+	// ErrInvalidMassEdit is returned when a mass tag edit has no search, doesn't
+	// change anything or changes tags which aren't plain tags.
+	ErrInvalidMassEdit = errors.New("Mass tag edits must have a search and add, remove or rename plain tags")
+	// ErrMassEditNotExist is returned when a mass tag edit does not exist.
+	ErrMassEditNotExist = errors.New("Mass tag edit does not exist")
+	// ErrMassEditUndone is returned when undoing a mass tag edit which was already undone.
+	ErrMassEditUndone = errors.New("Mass tag edit has already been undone")
+)
+
+// ParseTagRenames parses renames written one per line as "from to".
+func ParseTagRenames(s string) ([]types.TagRename, error) {
+	renames := make([]types.TagRename, 0)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, ErrInvalidMassEdit
+		}
+		renames = append(renames, types.TagRename{From: fields[0], To: fields[1]})
+	}
+	return renames, nil
+}
+
+// massEditTags filters and checks the tags in a list, replacing aliased
+// tags with the tag they are a alias of.
+func (db *DB) massEditTags(ctx context.Context, tags []string) ([]string, bool) {
+	filtered := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if !plainTag(tag) {
+			return nil, false
+		}
+		filtered = append(filtered, db.AliasedTag(ctx, tag))
+	}
+	return filtered, true
+}
+
+// checkMassTagEdit filters the search and tags of a mass tag edit and
+// checks it changes something.
+func (db *DB) checkMassTagEdit(ctx context.Context, e *types.MassTagEdit) error {
+	e.Query = strings.TrimSpace(e.Query)
+	var ok bool
+	if e.Add, ok = db.massEditTags(ctx, e.Add); !ok {
+		return ErrInvalidMassEdit
+	}
+	if e.Remove, ok = db.massEditTags(ctx, e.Remove); !ok {
+		return ErrInvalidMassEdit
+	}
+	renames := make([]types.TagRename, 0, len(e.Renames))
+	for _, r := range e.Renames {
+		// The tag renamed from isn't aliased as posts can't have aliased tags.
+		from := strings.ToLower(strings.TrimSpace(r.From))
+		to, ok := db.massEditTags(ctx, []string{r.To})
+		if !plainTag(from) || !ok || len(to) != 1 {
+			return ErrInvalidMassEdit
+		}
+		if from != to[0] {
+			renames = append(renames, types.TagRename{From: from, To: to[0]})
+		}
+	}
+	e.Renames = renames
+	if e.Query == "" || len(e.Add)+len(e.Remove)+len(e.Renames) == 0 {
+		return ErrInvalidMassEdit
+	}
+	return nil
+}
+
+// applyMassTagEdit returns a post's tags after the renames, removals and
+// additions of a mass tag edit, in that order.
+func applyMassTagEdit(tags []string, e types.MassTagEdit) []string {
+	newTags := make([]string, 0, len(tags)+len(e.Add))
+	for _, tag := range tags {
+		for _, r := range e.Renames {
+			if tag == r.From {
+				tag = r.To
+				break
+			}
+		}
+		if !sliceContains(e.Remove, tag) && !sliceContains(newTags, tag) {
+			newTags = append(newTags, tag)
+		}
+	}
+	for _, tag := range e.Add {
+		if !sliceContains(newTags, tag) {
+			newTags = append(newTags, tag)
+		}
+	}
+	return newTags
+}
+
+// tagsDiff returns the tags in b which aren't in a, ignoring the wildcard.
+func tagsDiff(a, b []string) []string {
+	diff := make([]string, 0)
+	for _, tag := range removeWildcard(b) {
+		if tag != "" && !sliceContains(a, tag) && !sliceContains(diff, tag) {
+			diff = append(diff, tag)
+		}
+	}
+	return diff
+}
+
+// massEditPosts returns the IDs of the posts matching a mass tag edit's search.
+func (db *DB) massEditPosts(ctx context.Context, query string) ([]int64, error) {
+	return db.TagsPosts(ctx, db.filterTags(ctx, utils.SplitTagsString(query)))
+}
+
+// changedByMassTagEdit checks if a mass tag edit changes a post's tags
+// once aliases and implications have been applied.
+func (db *DB) changedByMassTagEdit(ctx context.Context, p types.Post, e types.MassTagEdit) bool {
+	newTags := db.addImplications(ctx, db.filterTags(ctx, removeMetatags(applyMassTagEdit(p.Tags, e))))
+	return len(tagsDiff(p.Tags, newTags)) != 0 || len(tagsDiff(newTags, p.Tags)) != 0
+}
+
+// PreviewMassTagEdit returns how many posts a mass tag edit would change
+// and the first few of them.
+func (db *DB) PreviewMassTagEdit(ctx context.Context, e types.MassTagEdit) (int, []types.Post, error) {
+	defer trace.StartRegion(ctx, "DB/PreviewMassTagEdit").End()
+
+	if err := db.checkMassTagEdit(ctx, &e); err != nil {
+		return 0, nil, err
+	}
+	postIDs, err := db.massEditPosts(ctx, e.Query)
+	if err != nil {
+		return 0, nil, err
+	}
+	count := 0
+	posts := make([]types.Post, 0)
+	for _, postID := range postIDs {
+		p, err := db.Post(ctx, postID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !db.changedByMassTagEdit(ctx, p, e) {
+			continue
+		}
+		count++
+		if len(posts) < massEditPreviewPosts {
+			posts = append(posts, p)
+		}
+	}
+	return count, posts, nil
+}
+
+// MassTagEdit changes the tags of every post matching a search and logs
+// the change so it can be undone, returning the edit's ID. Posts are
+// edited in batches, each in its own transaction.
+func (db *DB) MassTagEdit(ctx context.Context, username string, e types.MassTagEdit) (id int64, err error) {
+	defer trace.StartRegion(ctx, "DB/MassTagEdit").End()
+
+	if err = db.checkMassTagEdit(ctx, &e); err != nil {
+		return
+	}
+	postIDs, err := db.massEditPosts(ctx, e.Query)
+	if err != nil {
+		return
+	}
+	from := make([]string, len(e.Renames))
+	to := make([]string, len(e.Renames))
+	for i, r := range e.Renames {
+		from[i], to[i] = r.From, r.To
+	}
+	err = db.sqldb.QueryRowContext(ctx, `INSERT INTO mass_tag_edits("username", "query", "add", "remove", "renameFrom", "renameTo", "postCount", "timestamp") VALUES ($1, $2, $3, $4, $5, $6, 0, $7) RETURNING "id"`,
+		username, e.Query, strings.Join(e.Add, "+"), strings.Join(e.Remove, "+"), strings.Join(from, "+"), strings.Join(to, "+"), nowMillis()).Scan(&id)
+	if err != nil {
+		log.Warn().Err(err).Msg("MassTagEdit can't execute insert statement")
+		return
+	}
+	defer db.flushMassTagEditCaches(ctx)
+
+	for len(postIDs) != 0 {
+		n := massEditBatchSize
+		if n > len(postIDs) {
+			n = len(postIDs)
+		}
+		if err = db.massTagEditBatch(ctx, id, e, postIDs[:n]); err != nil {
+			return
+		}
+		postIDs = postIDs[n:]
+	}
+	log.Info().Str("username", username).Int64("id", id).Msg("Mass tag edit")
+	return
+}
+
+// lockPost reads the fields of a post which editPost saves as part of a
+// transaction, locking the post so its tags can't change until it ends.
+func lockPost(ctx context.Context, tx *sql.Tx, postID int64) (p types.Post, err error) {
+	var tags string
+	err = tx.QueryRowContext(ctx, `SELECT "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype", "rating", "parent" FROM posts WHERE postid = $1 FOR UPDATE`, postID).Scan(&p.Filename, &p.FileExtension, &p.Description, &tags, &p.Poster, &p.CreatedAt, &p.MimeType, &p.Rating, &p.Parent)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("lockPost can't select")
+		}
+		return
+	}
+	p.PostID = postID
+	p.Tags = utils.SplitTagsString(tags)
+	return
+}
+
+// massTagEditBatch edits the tags of a batch of posts for a mass tag edit.
+func (db *DB) massTagEditBatch(ctx context.Context, id int64, e types.MassTagEdit, postIDs []int64) error {
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, postID := range postIDs {
+		p, err := lockPost(ctx, tx, postID)
+		if err == sql.ErrNoRows {
+			// The post was deleted since the search.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !db.changedByMassTagEdit(ctx, p, e) {
+			continue
+		}
+		oldTags := p.Tags
+		p.Tags = applyMassTagEdit(oldTags, e)
+		newTags, err := db.editPost(ctx, tx, postID, p)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO mass_tag_edit_posts("editid", "postid", "added", "removed") VALUES ($1, $2, $3, $4)`,
+			id, postID, strings.Join(tagsDiff(oldTags, newTags), "+"), strings.Join(tagsDiff(newTags, oldTags), "+"))
+		if err != nil {
+			log.Warn().Err(err).Msg("MassTagEdit can't execute insert post statement")
+			return err
+		}
+		changed++
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE mass_tag_edits SET "postCount" = "postCount" + $1 WHERE "id" = $2`, changed, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("MassTagEdit can't execute update statement")
+		return err
+	}
+	return tx.Commit()
+}
+
+// UndoMassTagEdit reverts the changes a mass tag edit made to each post's
+// tags, leaving any other changes made to the posts since.
+func (db *DB) UndoMassTagEdit(ctx context.Context, username string, id int64) error {
+	defer trace.StartRegion(ctx, "DB/UndoMassTagEdit").End()
+
+	var undoneBy string
+	err := db.sqldb.QueryRowContext(ctx, `SELECT "undoneBy" FROM mass_tag_edits WHERE "id" = $1`, id).Scan(&undoneBy)
+	if err == sql.ErrNoRows {
+		return ErrMassEditNotExist
+	} else if err != nil {
+		log.Error().Err(err).Msg("UndoMassTagEdit can't query statement")
+		return err
+	}
+	if undoneBy != "" {
+		return ErrMassEditUndone
+	}
+	defer db.flushMassTagEditCaches(ctx)
+
+	// Batches are undone from the start each time as undone posts are
+	// removed from the log.
+	for {
+		n, err := db.undoMassTagEditBatch(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	_, err = db.sqldb.ExecContext(ctx, `UPDATE mass_tag_edits SET "undoneBy" = $1 WHERE "id" = $2`, username, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("UndoMassTagEdit can't execute update statement")
+		return err
+	}
+	log.Info().Str("username", username).Int64("id", id).Msg("Undid mass tag edit")
+	return nil
+}
+
+// undoMassTagEditBatch undoes a mass tag edit on a batch of posts,
+// returning how many posts were in the batch.
+func (db *DB) undoMassTagEditBatch(ctx context.Context, id int64) (int, error) {
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT "postid", "added", "removed" FROM mass_tag_edit_posts WHERE "editid" = $1 ORDER BY "postid" LIMIT $2`, id, massEditBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("UndoMassTagEdit can't query posts")
+		return 0, err
+	}
+	undo := make(map[int64]types.MassTagEdit)
+	for rows.Next() {
+		var postID int64
+		var added, removed string
+		if err := rows.Scan(&postID, &added, &removed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		// Undoing removes the tags which were added and adds back the tags which were removed.
+		undo[postID] = types.MassTagEdit{Add: splitTags(removed), Remove: splitTags(added)}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for postID, e := range undo {
+		p, err := lockPost(ctx, tx, postID)
+		if err == nil {
+			p.Tags = applyMassTagEdit(p.Tags, e)
+			if _, err = db.editPost(ctx, tx, postID, p); err != nil {
+				return 0, err
+			}
+		} else if err != sql.ErrNoRows {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM mass_tag_edit_posts WHERE "editid" = $1 AND "postid" = $2`, id, postID)
+		if err != nil {
+			log.Warn().Err(err).Msg("UndoMassTagEdit can't execute delete statement")
+			return 0, err
+		}
+	}
+	return len(undo), tx.Commit()
+}
+
+// splitTags splits a list of tags joined with +, returning a empty list
+// for a empty string.
+func splitTags(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "+")
+}
+
+// flushMassTagEditCaches flushes the caches which depend on post tags.
+func (db *DB) flushMassTagEditCaches(ctx context.Context) {
+	searchCache.Flush(ctx)
+	tagCountsCache.Flush(ctx)
+	autocompleteCache.Flush(ctx)
+}
+
+// MassTagEdits returns a page of the mass tag edit log, newest first,
+// along with the number of pages.
+func (db *DB) MassTagEdits(ctx context.Context, page int) ([]types.MassTagEdit, int, error) {
+	defer trace.StartRegion(ctx, "DB/MassTagEdits").End()
+
+	var count int64
+	if err := db.sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM mass_tag_edits`).Scan(&count); err != nil {
+		log.Error().Err(err).Msg("MassTagEdits can't count edits")
+		return nil, 0, err
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "id", "username", "query", "add", "remove", "renameFrom", "renameTo", "postCount", "timestamp", "undoneBy" FROM mass_tag_edits ORDER BY "id" DESC LIMIT $1 OFFSET $2`, massEditsPerPage, page*massEditsPerPage)
+	if err != nil {
+		log.Error().Err(err).Msg("MassTagEdits can't query statement")
+		return nil, 0, err
+	}
+	defer rows.Close()
+	edits := make([]types.MassTagEdit, 0)
+	for rows.Next() {
+		var e types.MassTagEdit
+		var add, remove, from, to string
+		if err := rows.Scan(&e.ID, &e.Username, &e.Query, &add, &remove, &from, &to, &e.PostCount, &e.CreatedAt, &e.UndoneBy); err != nil {
+			return nil, 0, err
+		}
+		e.Add, e.Remove = splitTags(add), splitTags(remove)
+		e.Renames = make([]types.TagRename, 0)
+		toTags := splitTags(to)
+		for i, tag := range splitTags(from) {
+			if i < len(toTags) {
+				e.Renames = append(e.Renames, types.TagRename{From: tag, To: toTags[i]})
+			}
+		}
+		edits = append(edits, e)
+	}
+	return edits, countPages(count, massEditsPerPage), rows.Err()
+}
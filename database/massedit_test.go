@@ -0,0 +1,50 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+func TestApplyMassTagEdit(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		edit types.MassTagEdit
+		want []string
+	}{
+		{"nothing", []string{"cat", "dog"}, types.MassTagEdit{}, []string{"cat", "dog"}},
+		{"add", []string{"cat"}, types.MassTagEdit{Add: []string{"dog", "cat"}}, []string{"cat", "dog"}},
+		{"remove", []string{"cat", "dog"}, types.MassTagEdit{Remove: []string{"cat", "bird"}}, []string{"dog"}},
+		{"rename", []string{"cat", "dog"}, types.MassTagEdit{Renames: []types.TagRename{{From: "cat", To: "kitten"}}}, []string{"kitten", "dog"}},
+		{"rename to existing tag", []string{"cat", "kitten"}, types.MassTagEdit{Renames: []types.TagRename{{From: "cat", To: "kitten"}}}, []string{"kitten"}},
+		{"rename before remove", []string{"cat"}, types.MassTagEdit{Renames: []types.TagRename{{From: "cat", To: "kitten"}}, Remove: []string{"kitten"}}, []string{}},
+		{"remove before add", []string{"cat"}, types.MassTagEdit{Add: []string{"cat"}, Remove: []string{"cat"}}, []string{"cat"}},
+		{"renames don't chain", []string{"a"}, types.MassTagEdit{Renames: []types.TagRename{{From: "a", To: "b"}, {From: "b", To: "c"}}}, []string{"b"}},
+		{"duplicates", []string{"cat", "cat"}, types.MassTagEdit{}, []string{"cat"}},
+	}
+	for _, test := range tests {
+		if got := applyMassTagEdit(test.tags, test.edit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: applyMassTagEdit(%q) = %q, want %q", test.name, test.tags, got, test.want)
+		}
+	}
+}
+
+func TestTagsDiff(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{[]string{}, []string{}, []string{}},
+		{[]string{"cat"}, []string{"cat", "dog"}, []string{"dog"}},
+		{[]string{"cat", "dog"}, []string{"cat"}, []string{}},
+		{[]string{}, []string{"dog", "dog", ""}, []string{"dog"}},
+		{[]string{}, []string{"*", "**", "dog"}, []string{"dog"}},
+	}
+	for _, test := range tests {
+		if got := tagsDiff(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tagsDiff(%q, %q) = %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/utils"
+)
+
+// searchQuery collects the arguments of a search's SQL query.
+type searchQuery struct {
+	args []interface{}
+}
+
+// arg adds a argument to the query and returns its placeholder.
+func (q *searchQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// metatag returns a SQL condition on posts.postid matching posts for a
+// search term of the form name:value, which isn't a tag stored in the tag map.
+type metatag func(q *searchQuery, value string) string
+
+// metatags are the search terms matched by their own conditions, by name.
+var metatags = map[string]metatag{
+	"fav":    favMetatag,
+	"score":  scoreMetatag,
+	"has":    hasMetatag,
+	"pool":   poolMetatag,
+	"parent": parentMetatag,
+	"child":  childMetatag,
+}
+
+// orderPrefix is the start of the search term which chooses how results are sorted.
+const orderPrefix = "order:"
+
+// orders are the ORDER BY clauses for order:<name>, newest first by default.
+// Post IDs are snowflakes so ordering by them orders by upload time.
+var orders = map[string]string{
+	"new":       "postid DESC",
+	"old":       "postid ASC",
+	"score":     "score DESC, postid DESC",
+	"score_asc": "score ASC, postid DESC",
+}
+
+// comparison returns a SQL condition comparing a column to a value such as
+// ">10", ">=10", "<10", "<=10" or "10", or false if the value is invalid.
+func comparison(q *searchQuery, column string, value string) string {
+	op := "="
+	for _, o := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, o) {
+			op = o
+			value = strings.TrimPrefix(value, o)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "false"
+	}
+	return column + " " + op + " " + q.arg(n)
+}
+
+// scoreMetatag matches posts by their score with score:>N and similar.
+func scoreMetatag(q *searchQuery, value string) string {
+	return comparison(q, "score", value)
+}
+
+// parseMetatag returns the metatag and its value if a tag is a metatag.
+func parseMetatag(tag string) (metatag, string, bool) {
+	parts := strings.SplitN(tag, ":", 2)
+	if len(parts) != 2 {
+		return nil, "", false
+	}
+	m, ok := metatags[parts[0]]
+	return m, parts[1], ok
+}
+
+// removeMetatags removes tags which would be matched as metatags or
+// wildcard patterns from a post's tags, as they could never be searched for.
+func removeMetatags(tags []string) []string {
+	newTags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if _, _, ok := parseMetatag(tag); !ok && !strings.HasPrefix(tag, orderPrefix) && !isWildcardPattern(tag) {
+			newTags = append(newTags, tag)
+		}
+	}
+	return newTags
+}
+
+// normaliseSearchTags normalises search terms. Metatags and order: keep
+// their values with FilterTag, wildcard patterns keep their * and the rest
+// are normalised the same way as stored tags so they can match them.
+func normaliseSearchTags(tags []string) []string {
+	normalised := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		prefix := ""
+		if strings.HasPrefix(tag, "-") {
+			prefix = "-"
+			tag = tag[1:]
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, _, ok := parseMetatag(tag); ok || strings.HasPrefix(tag, orderPrefix) {
+			tag = utils.FilterTag(tag)
+		} else {
+			parts := strings.Split(tag, "*")
+			for i, part := range parts {
+				parts[i] = utils.FilterString(part)
+			}
+			tag = strings.Join(parts, "*")
+		}
+		if tag != "" {
+			normalised = append(normalised, prefix+tag)
+		}
+	}
+	return normalised
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormaliseSearchTags(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{[]string{"Cat", " dog "}, []string{"cat", "dog"}},
+		{[]string{"2girls", "cat.ears"}, []string{"girls", "catears"}},
+		{[]string{"-Cat", "-"}, []string{"-cat"}},
+		{[]string{"score:>=10", "-fav:kitten", "pool:12"}, []string{"score:>=10", "-fav:kitten", "pool:12"}},
+		{[]string{"order:score_asc", "rating:s"}, []string{"order:score_asc", "rating:s"}},
+		{[]string{"cat_*", "*2*ears", "*"}, []string{"cat_*", "**ears", "*"}},
+		{[]string{"", "<>"}, []string{}},
+	}
+	for _, test := range tests {
+		if got := normaliseSearchTags(test.tags); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("normaliseSearchTags(%q) = %q, want %q", test.tags, got, test.want)
+		}
+	}
+}
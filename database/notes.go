@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// maxNoteLength is the longest a note can be in bytes.
+const maxNoteLength = 5000
+
+var (
+	// ErrNoteNotExist is returned when a note does not exist.
+	ErrNoteNotExist = errors.New("Note does not exist")
+	// ErrInvalidNote is returned when a note is empty, too long or has a invalid region.
+	ErrInvalidNote = errors.New("Notes must have a region inside the image and not be empty or longer than 5000 characters")
+)
+
+// hasMetatag matches posts which have something attached, currently only has:notes.
+func hasMetatag(q *searchQuery, value string) string {
+	switch value {
+	case "notes":
+		return `postid IN (SELECT postid FROM notes)`
+	}
+	return "false"
+}
+
+// validNote checks a note has a region and its body isn't empty or too long.
+func validNote(rect types.NoteRect, body string) bool {
+	return rect.X >= 0 && rect.Y >= 0 && rect.Width > 0 && rect.Height > 0 &&
+		len(strings.TrimSpace(body)) != 0 && len(body) <= maxNoteLength
+}
+
+// noteColumns are the columns scanned by scanNotes.
+const noteColumns = `"id", "postid", "username", "x", "y", "width", "height", "body", "version", "timestamp", "editedAt"`
+
+// scanNotes reads notes from rows selecting noteColumns.
+func scanNotes(rows *sql.Rows) ([]types.Note, error) {
+	defer rows.Close()
+	notes := make([]types.Note, 0)
+	for rows.Next() {
+		var n types.Note
+		if err := rows.Scan(&n.ID, &n.PostID, &n.Username, &n.X, &n.Y, &n.Width, &n.Height, &n.Body, &n.Version, &n.CreatedAt, &n.EditedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// addNoteVersion records a version of a note in its history.
+func addNoteVersion(ctx context.Context, tx *sql.Tx, v types.NoteVersion) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO note_versions("noteid", "postid", "version", "username", "x", "y", "width", "height", "body", "deleted", "timestamp") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		v.NoteID, v.PostID, v.Version, v.Username, v.X, v.Y, v.Width, v.Height, v.Body, v.Deleted, v.CreatedAt)
+	if err != nil {
+		log.Warn().Err(err).Msg("addNoteVersion can't execute insert statement")
+	}
+	return err
+}
+
+// AddNote adds a note to a post, returning the note's ID.
+func (db *DB) AddNote(ctx context.Context, username string, postID int64, rect types.NoteRect, body string) (id int64, err error) {
+	defer trace.StartRegion(ctx, "DB/AddNote").End()
+
+	if !validNote(rect, body) {
+		return 0, ErrInvalidNote
+	}
+	if _, err = db.Post(ctx, postID); err != nil {
+		return 0, PostNotExistError
+	}
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := nowMillis()
+	err = tx.QueryRowContext(ctx, `INSERT INTO notes("postid", "username", "x", "y", "width", "height", "body", "version", "timestamp", "editedAt") VALUES ($1, $2, $3, $4, $5, $6, $7, 1, $8, 0) RETURNING "id"`,
+		postID, username, rect.X, rect.Y, rect.Width, rect.Height, body, now).Scan(&id)
+	if err != nil {
+		log.Warn().Err(err).Msg("AddNote can't execute insert statement")
+		return 0, err
+	}
+	err = addNoteVersion(ctx, tx, types.NoteVersion{NoteID: id, PostID: postID, Version: 1, Username: username, NoteRect: rect, Body: body, CreatedAt: now})
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	searchCache.Flush(ctx)
+	return id, nil
+}
+
+// Note fetches a note from the database.
+func (db *DB) Note(ctx context.Context, id int64) (n types.Note, err error) {
+	defer trace.StartRegion(ctx, "DB/Note").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+noteColumns+` FROM notes WHERE "id" = $1`, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Note can't query statement")
+		return
+	}
+	notes, err := scanNotes(rows)
+	if err != nil {
+		return
+	}
+	if len(notes) == 0 {
+		return n, ErrNoteNotExist
+	}
+	return notes[0], nil
+}
+
+// PostNotes returns all the notes on a post, oldest first.
+func (db *DB) PostNotes(ctx context.Context, postID int64) ([]types.Note, error) {
+	defer trace.StartRegion(ctx, "DB/PostNotes").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+noteColumns+` FROM notes WHERE "postid" = $1 ORDER BY "id" ASC`, postID)
+	if err != nil {
+		log.Error().Err(err).Msg("PostNotes can't query statement")
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+// EditNote changes the region and body of a note, recording the change
+// as a new version by the editing user.
+func (db *DB) EditNote(ctx context.Context, username string, id int64, rect types.NoteRect, body string) error {
+	defer trace.StartRegion(ctx, "DB/EditNote").End()
+
+	if !validNote(rect, body) {
+		return ErrInvalidNote
+	}
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := nowMillis()
+	v := types.NoteVersion{NoteID: id, Username: username, NoteRect: rect, Body: body, CreatedAt: now}
+	err = tx.QueryRowContext(ctx, `UPDATE notes SET "x" = $1, "y" = $2, "width" = $3, "height" = $4, "body" = $5, "version" = "version" + 1, "editedAt" = $6 WHERE "id" = $7 RETURNING "postid", "version"`,
+		rect.X, rect.Y, rect.Width, rect.Height, body, now, id).Scan(&v.PostID, &v.Version)
+	if err == sql.ErrNoRows {
+		return ErrNoteNotExist
+	} else if err != nil {
+		log.Warn().Err(err).Msg("EditNote can't execute update statement")
+		return err
+	}
+	if err = addNoteVersion(ctx, tx, v); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteNote deletes a note, keeping its history with a final version
+// recording who deleted it.
+func (db *DB) DeleteNote(ctx context.Context, username string, id int64) error {
+	defer trace.StartRegion(ctx, "DB/DeleteNote").End()
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	v := types.NoteVersion{NoteID: id, Username: username, Deleted: true, CreatedAt: nowMillis()}
+	err = tx.QueryRowContext(ctx, `DELETE FROM notes WHERE "id" = $1 RETURNING "postid", "version" + 1, "x", "y", "width", "height", "body"`, id).
+		Scan(&v.PostID, &v.Version, &v.X, &v.Y, &v.Width, &v.Height, &v.Body)
+	if err == sql.ErrNoRows {
+		return ErrNoteNotExist
+	} else if err != nil {
+		log.Warn().Err(err).Msg("DeleteNote can't execute delete statement")
+		return err
+	}
+	if err = addNoteVersion(ctx, tx, v); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// PostNoteVersions returns the history of every note on a post, including
+// deleted notes, newest first.
+func (db *DB) PostNoteVersions(ctx context.Context, postID int64) ([]types.NoteVersion, error) {
+	defer trace.StartRegion(ctx, "DB/PostNoteVersions").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "noteid", "postid", "version", "username", "x", "y", "width", "height", "body", "deleted", "timestamp" FROM note_versions WHERE "postid" = $1 ORDER BY "timestamp" DESC, "noteid" DESC, "version" DESC`, postID)
+	if err != nil {
+		log.Error().Err(err).Msg("PostNoteVersions can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	versions := make([]types.NoteVersion, 0)
+	for rows.Next() {
+		var v types.NoteVersion
+		if err := rows.Scan(&v.NoteID, &v.PostID, &v.Version, &v.Username, &v.X, &v.Y, &v.Width, &v.Height, &v.Body, &v.Deleted, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// parentTagPrefix is the start of the tag which can be used instead of the
+// parent field to set a post's parent when uploading or editing.
+const parentTagPrefix = "parent:"
+
+// ErrInvalidParent is returned when a post's parent doesn't exist, is the
+// post itself or is one of the post's children.
+var ErrInvalidParent = errors.New("Parent post must exist and can't be the post or one of its children")
+
+// parentMetatag matches a post and its children with parent:id, posts with
+// a parent with parent:any and posts without one with parent:none.
+func parentMetatag(q *searchQuery, value string) string {
+	switch value {
+	case "any":
+		return "parent <> 0"
+	case "none":
+		return "parent = 0"
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "false"
+	}
+	arg := q.arg(id)
+	return "(postid = " + arg + " OR parent = " + arg + ")"
+}
+
+// childMetatag matches posts with children with child:any and posts
+// without any with child:none.
+func childMetatag(q *searchQuery, value string) string {
+	cond := `postid IN (SELECT parent FROM posts WHERE parent <> 0)`
+	switch value {
+	case "any":
+		return cond
+	case "none":
+		return "NOT " + cond
+	}
+	return "false"
+}
+
+// childFilter hides child posts from searches when HideChildPosts is set,
+// unless the search asks about parents or children itself.
+func (db *DB) childFilter(tags []string) []string {
+	if !db.Settings.HideChildPosts {
+		return tags
+	}
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(tag, "-")
+		if strings.HasPrefix(tag, parentTagPrefix) || strings.HasPrefix(tag, "child:") {
+			return tags
+		}
+	}
+	return append(tags, "parent:none")
+}
+
+// ParentFromTags removes any parent tags from a post's tags, returning the
+// remaining tags and the parent post ID they asked for, or 0 if none.
+func ParentFromTags(tags []string) ([]string, int64) {
+	var parent int64
+	newTags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if strings.HasPrefix(strings.ToLower(tag), parentTagPrefix) {
+			if id, err := strconv.ParseInt(tag[len(parentTagPrefix):], 10, 64); err == nil {
+				parent = id
+			}
+			continue
+		}
+		newTags = append(newTags, tag)
+	}
+	return newTags, parent
+}
+
+// CheckParent checks a post can have a parent, which must exist and can't
+// be the post or any of its descendants. postID is 0 for new posts.
+func (db *DB) CheckParent(ctx context.Context, postID int64, parent int64) error {
+	defer trace.StartRegion(ctx, "DB/CheckParent").End()
+
+	return checkParent(postID, parent, func(id int64) (int64, error) {
+		var p int64
+		err := db.sqldb.QueryRowContext(ctx, `SELECT parent FROM posts WHERE postid = $1`, id).Scan(&p)
+		if err != nil && err != sql.ErrNoRows {
+			log.Warn().Err(err).Msg("CheckParent can't select parent")
+		}
+		return p, err
+	})
+}
+
+// checkParent does the checks of CheckParent, using parentOf to look up
+// the parent of a post which returns sql.ErrNoRows if it doesn't exist.
+func checkParent(postID int64, parent int64, parentOf func(id int64) (int64, error)) error {
+	if parent == 0 {
+		return nil
+	}
+	// Walk up from the new parent, if the post is found then it would
+	// become its own ancestor.
+	seen := make(map[int64]bool)
+	for id := parent; id != 0; {
+		if id == postID || seen[id] {
+			return ErrInvalidParent
+		}
+		seen[id] = true
+		next, err := parentOf(id)
+		if err == sql.ErrNoRows {
+			// The parent has to exist, but further up a missing post
+			// just ends the chain.
+			if id == parent {
+				return ErrInvalidParent
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
+
+// PostChildren returns the IDs of a post's children, oldest first.
+func (db *DB) PostChildren(ctx context.Context, postID int64) ([]int64, error) {
+	defer trace.StartRegion(ctx, "DB/PostChildren").End()
+
+	children := make([]int64, 0)
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT postid FROM posts WHERE parent = $1 ORDER BY postid ASC`, postID)
+	if err != nil {
+		log.Error().Err(err).Msg("PostChildren can't query statement")
+		return children, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return children, err
+		}
+		children = append(children, id)
+	}
+	return children, rows.Err()
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCheckParent(t *testing.T) {
+	// Post 1 has no parent, 2 and 3 are children of 1, 4 is a child of 2,
+	// 5's parent doesn't exist and 6 and 7 are each other's parents.
+	parents := map[int64]int64{1: 0, 2: 1, 3: 1, 4: 2, 5: 99, 6: 7, 7: 6}
+	parentOf := func(id int64) (int64, error) {
+		p, ok := parents[id]
+		if !ok {
+			return 0, sql.ErrNoRows
+		}
+		return p, nil
+	}
+	tests := []struct {
+		postID, parent int64
+		ok             bool
+	}{
+		{0, 0, true},
+		{0, 1, true},
+		{0, 4, true},
+		{0, 99, false},
+		{0, 5, true},
+		{3, 4, true},
+		{1, 0, true},
+		{1, 1, false},
+		{1, 2, false},
+		{1, 4, false},
+		{2, 4, false},
+		{4, 3, true},
+		{0, 6, false},
+	}
+	for _, test := range tests {
+		err := checkParent(test.postID, test.parent, parentOf)
+		if (err == nil) != test.ok {
+			t.Errorf("checkParent(%d, %d) = %v, want ok %v", test.postID, test.parent, err, test.ok)
+		}
+		if err != nil && err != ErrInvalidParent {
+			t.Errorf("checkParent(%d, %d) = %v, want ErrInvalidParent", test.postID, test.parent, err)
+		}
+	}
+}
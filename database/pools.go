@@ -0,0 +1,291 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strconv"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// poolsPerPage is how many pools are in each page of the pool list.
+const poolsPerPage = 50
+
+// maxPoolNameLength and maxPoolDescriptionLength are the longest a pool's
+// name and description can be in bytes.
+const (
+	maxPoolNameLength        = 200
+	maxPoolDescriptionLength = 10000
+)
+
+var (
+	// ErrPoolNotExist is returned when a pool does not exist.
+	ErrPoolNotExist = errors.New("Pool does not exist")
+	// ErrInvalidPool is returned when a pool's name is empty or its name or description is too long.
+	ErrInvalidPool = errors.New("Pools must have a name no longer than 200 characters and a description no longer than 10000 characters")
+)
+
+// poolMetatag matches posts in a pool with pool:id.
+func poolMetatag(q *searchQuery, value string) string {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "false"
+	}
+	return `postid IN (SELECT postid FROM pool_posts WHERE poolid = ` + q.arg(id) + `)`
+}
+
+// validPool checks a pool's name isn't empty and neither it or the description are too long.
+func validPool(name, description string) bool {
+	return len(strings.TrimSpace(name)) != 0 && len(name) <= maxPoolNameLength && len(description) <= maxPoolDescriptionLength
+}
+
+// poolColumns are the columns scanned by scanPools.
+const poolColumns = `"id", "name", "description", "owner", "timestamp", "updatedAt", (SELECT COUNT(*) FROM pool_posts WHERE pool_posts.poolid = pools.id)`
+
+// scanPools reads pools from rows selecting poolColumns.
+func scanPools(rows *sql.Rows) ([]types.Pool, error) {
+	defer rows.Close()
+	pools := make([]types.Pool, 0)
+	for rows.Next() {
+		var p types.Pool
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Owner, &p.CreatedAt, &p.UpdatedAt, &p.PostCount); err != nil {
+			return nil, err
+		}
+		pools = append(pools, p)
+	}
+	return pools, rows.Err()
+}
+
+// CreatePool creates a empty pool, returning the pool's ID.
+func (db *DB) CreatePool(ctx context.Context, owner, name, description string) (id int64, err error) {
+	defer trace.StartRegion(ctx, "DB/CreatePool").End()
+
+	if !validPool(name, description) {
+		return 0, ErrInvalidPool
+	}
+	now := nowMillis()
+	err = db.sqldb.QueryRowContext(ctx, `INSERT INTO pools("name", "description", "owner", "timestamp", "updatedAt") VALUES ($1, $2, $3, $4, $4) RETURNING "id"`, name, description, owner, now).Scan(&id)
+	if err != nil {
+		log.Warn().Err(err).Msg("CreatePool can't execute insert statement")
+	}
+	return
+}
+
+// Pool fetches a pool and the IDs of its posts in order from the database.
+func (db *DB) Pool(ctx context.Context, id int64) (p types.Pool, err error) {
+	defer trace.StartRegion(ctx, "DB/Pool").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+poolColumns+` FROM pools WHERE "id" = $1`, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Pool can't query statement")
+		return
+	}
+	pools, err := scanPools(rows)
+	if err != nil {
+		return
+	}
+	if len(pools) == 0 {
+		return p, ErrPoolNotExist
+	}
+	p = pools[0]
+
+	rows, err = db.sqldb.QueryContext(ctx, `SELECT "postid" FROM pool_posts WHERE "poolid" = $1 ORDER BY "position" ASC`, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Pool can't query posts statement")
+		return
+	}
+	defer rows.Close()
+	p.Posts = make([]int64, 0, p.PostCount)
+	for rows.Next() {
+		var postID int64
+		if err = rows.Scan(&postID); err != nil {
+			return
+		}
+		p.Posts = append(p.Posts, postID)
+	}
+	return p, rows.Err()
+}
+
+// Pools returns a page of pools, most recently changed first, along with
+// how many pages of pools there are.
+func (db *DB) Pools(ctx context.Context, page int) ([]types.Pool, int, error) {
+	defer trace.StartRegion(ctx, "DB/Pools").End()
+
+	var count int64
+	err := db.sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM pools`).Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("Pools can't count pools")
+		return nil, 0, err
+	}
+	if page < 0 {
+		page = 0
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+poolColumns+` FROM pools ORDER BY "updatedAt" DESC, "id" DESC LIMIT $1 OFFSET $2`, poolsPerPage, page*poolsPerPage)
+	if err != nil {
+		log.Error().Err(err).Msg("Pools can't query statement")
+		return nil, 0, err
+	}
+	pools, err := scanPools(rows)
+	return pools, countPages(count, poolsPerPage), err
+}
+
+// EditPool changes the name and description of a pool.
+func (db *DB) EditPool(ctx context.Context, id int64, name, description string) error {
+	defer trace.StartRegion(ctx, "DB/EditPool").End()
+
+	if !validPool(name, description) {
+		return ErrInvalidPool
+	}
+	_, err := db.sqldb.ExecContext(ctx, `UPDATE pools SET "name" = $1, "description" = $2, "updatedAt" = $3 WHERE "id" = $4`, name, description, nowMillis(), id)
+	if err != nil {
+		log.Warn().Err(err).Msg("EditPool can't execute update statement")
+	}
+	return err
+}
+
+// DeletePool deletes a pool, leaving its posts alone.
+func (db *DB) DeletePool(ctx context.Context, id int64) error {
+	defer trace.StartRegion(ctx, "DB/DeletePool").End()
+
+	_, err := db.sqldb.ExecContext(ctx, `DELETE FROM pool_posts WHERE "poolid" = $1`, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePool can't execute delete posts statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `DELETE FROM pools WHERE "id" = $1`, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePool can't execute delete statement")
+		return err
+	}
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// touchPool marks a pool as changed now.
+func touchPool(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE pools SET "updatedAt" = $1 WHERE "id" = $2`, nowMillis(), id)
+	if err != nil {
+		log.Warn().Err(err).Msg("touchPool can't execute update statement")
+	}
+	return err
+}
+
+// SetPoolPosts replaces the posts in a pool with postIDs in that order,
+// which adds, removes and reorders posts all at once. Duplicate IDs and
+// IDs of posts which don't exist are skipped.
+func (db *DB) SetPoolPosts(ctx context.Context, id int64, postIDs []int64) error {
+	defer trace.StartRegion(ctx, "DB/SetPoolPosts").End()
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM pool_posts WHERE "poolid" = $1`, id)
+	if err != nil {
+		log.Warn().Err(err).Msg("SetPoolPosts can't execute delete statement")
+		return err
+	}
+	for i, postID := range postIDs {
+		_, err = tx.ExecContext(ctx, `INSERT INTO pool_posts("poolid", "postid", "position") SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM posts WHERE postid = $2) ON CONFLICT DO NOTHING`, id, postID, i)
+		if err != nil {
+			log.Warn().Err(err).Msg("SetPoolPosts can't execute insert statement")
+			return err
+		}
+	}
+	if err = touchPool(ctx, tx, id); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// AddPoolPost adds a post to the end of a pool, doing nothing if it's already in it.
+func (db *DB) AddPoolPost(ctx context.Context, id int64, postID int64) error {
+	defer trace.StartRegion(ctx, "DB/AddPoolPost").End()
+
+	if _, err := db.Post(ctx, postID); err != nil {
+		return PostNotExistError
+	}
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO pool_posts("poolid", "postid", "position") VALUES ($1, $2, (SELECT COALESCE(MAX("position") + 1, 0) FROM pool_posts WHERE "poolid" = $1)) ON CONFLICT DO NOTHING`, id, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("AddPoolPost can't execute insert statement")
+		return err
+	}
+	if err = touchPool(ctx, tx, id); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// RemovePoolPost removes a post from a pool.
+func (db *DB) RemovePoolPost(ctx context.Context, id int64, postID int64) error {
+	defer trace.StartRegion(ctx, "DB/RemovePoolPost").End()
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM pool_posts WHERE "poolid" = $1 AND "postid" = $2`, id, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("RemovePoolPost can't execute delete statement")
+		return err
+	}
+	if err = touchPool(ctx, tx, id); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	searchCache.Flush(ctx)
+	return nil
+}
+
+// PostPools returns the pools a post is in with the posts before and after
+// it in each of them.
+func (db *DB) PostPools(ctx context.Context, postID int64) ([]types.PoolNav, error) {
+	defer trace.StartRegion(ctx, "DB/PostPools").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT pools."id", pools."name", n.prev, n.next FROM (
+		SELECT "poolid", "postid", LAG("postid") OVER w AS prev, LEAD("postid") OVER w AS next
+		FROM pool_posts WHERE "poolid" IN (SELECT "poolid" FROM pool_posts WHERE "postid" = $1)
+		WINDOW w AS (PARTITION BY "poolid" ORDER BY "position")
+	) n JOIN pools ON pools."id" = n."poolid" WHERE n."postid" = $1 ORDER BY pools."id"`, postID)
+	if err != nil {
+		log.Error().Err(err).Msg("PostPools can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	navs := make([]types.PoolNav, 0)
+	for rows.Next() {
+		var nav types.PoolNav
+		var prev, next sql.NullInt64
+		if err := rows.Scan(&nav.PoolID, &nav.Name, &prev, &next); err != nil {
+			return nil, err
+		}
+		nav.Prev = prev.Int64
+		nav.Next = next.Int64
+		navs = append(navs, nav)
+	}
+	return navs, rows.Err()
+}
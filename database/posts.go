@@ -7,11 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"runtime/trace"
-	"strconv"
 
 	"github.com/NamedKitten/kittehbooru/utils"
 
 	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,7 +29,7 @@ func (db *DB) Post(ctx context.Context, postID int64) (p types.Post, err error)
 	var tags string
 
 	// Query for the post
-	err = db.sqldb.QueryRowContext(ctx, `select "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype" from posts where postID = $1`, postID).Scan(&p.Filename, &p.FileExtension, &p.Description, &tags, &p.Poster, &p.CreatedAt, &p.MimeType)
+	err = db.sqldb.QueryRowContext(ctx, `select "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype", "size", "rating", "score", "parent", (SELECT COUNT(*) FROM favorites WHERE favorites.postid = posts.postid) from posts where postID = $1`, postID).Scan(&p.Filename, &p.FileExtension, &p.Description, &tags, &p.Poster, &p.CreatedAt, &p.MimeType, &p.Size, &p.Rating, &p.Score, &p.Parent, &p.Favorites)
 	if err != nil {
 		log.Error().Err(err).Msg("Post can't select")
 		return
@@ -44,14 +44,20 @@ func (db *DB) Post(ctx context.Context, postID int64) (p types.Post, err error)
 func (db *DB) AddPost(ctx context.Context, post types.Post) (err error) {
 	defer trace.StartRegion(ctx, "DB/AddPost").End()
 
-	post.Tags = db.filterTags(post.Tags)
+	post.Tags = db.addImplications(ctx, db.filterTags(ctx, removeMetatags(post.Tags)))
+	if !types.ValidRating(post.Rating) {
+		post.Rating = db.DefaultRating()
+	}
+	if err = db.CheckParent(ctx, post.PostID, post.Parent); err != nil {
+		return
+	}
 
 	tagCountsCache.Delete(ctx, "*")
 	for _, tag := range post.Tags {
 		tagCountsCache.Delete(ctx, tag)
 	}
 
-	_, err = db.sqldb.ExecContext(ctx, `INSERT INTO "posts"("postid", "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype") VALUES ($1,$2,$3,$4,$5,$6,$7, $8)`, post.PostID, post.Filename, post.FileExtension, post.Description, utils.TagsListToString(post.Tags), post.Poster, post.CreatedAt, post.MimeType)
+	_, err = db.sqldb.ExecContext(ctx, `INSERT INTO "posts"("postid", "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype", "size", "rating", "parent") VALUES ($1,$2,$3,$4,$5,$6,$7, $8, $9, $10, $11)`, post.PostID, post.Filename, post.FileExtension, post.Description, utils.TagsListToString(post.Tags), post.Poster, post.CreatedAt, post.MimeType, post.Size, post.Rating, post.Parent)
 	if err != nil {
 		log.Warn().Err(err).Msg("AddPost can't execute insert post statement")
 		return
@@ -65,17 +71,37 @@ func (db *DB) AddPost(ctx context.Context, post types.Post) (err error) {
 func (db *DB) EditPost(ctx context.Context, postID int64, p types.Post) (err error) {
 	defer trace.StartRegion(ctx, "DB/EditPost").End()
 
-	p.Tags = db.filterTags(p.Tags)
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err = db.editPost(ctx, tx, postID, p); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// editPost edits a post as part of a transaction, returning the tags it
+// was saved with after aliases and implications.
+func (db *DB) editPost(ctx context.Context, tx *sql.Tx, postID int64, p types.Post) ([]string, error) {
+	p.Tags = db.addImplications(ctx, db.filterTags(ctx, removeMetatags(p.Tags)))
+	if !types.ValidRating(p.Rating) {
+		p.Rating = db.DefaultRating()
+	}
+	if err := db.CheckParent(ctx, postID, p.Parent); err != nil {
+		return nil, err
+	}
 
 	tags := utils.TagsListToString(p.Tags)
-	_, err = db.sqldb.ExecContext(ctx, `update posts set "filename"=$1, "ext"=$2, "description"=$3, "tags"=$4, "poster"=$5, "timestamp"=$6, "mimetype"=$7 where postid = $8`, p.Filename, p.FileExtension, p.Description, tags, p.Poster, p.CreatedAt, p.MimeType, postID)
+	_, err := tx.ExecContext(ctx, `update posts set "filename"=$1, "ext"=$2, "description"=$3, "tags"=$4, "poster"=$5, "timestamp"=$6, "mimetype"=$7, "rating"=$8, "parent"=$9 where postid = $10`, p.Filename, p.FileExtension, p.Description, tags, p.Poster, p.CreatedAt, p.MimeType, p.Rating, p.Parent, postID)
 	if err != nil {
 		log.Warn().Err(err).Msg("EditPost can't execute statement")
-		return
+		return nil, err
 	}
 
-	err = db.AddPostTags(ctx, p)
-	return
+	p.PostID = postID
+	return p.Tags, db.addPostTags(ctx, tx, p)
 }
 
 // DeletePost deletes a post from the database
@@ -98,9 +124,54 @@ func (db *DB) DeletePost(ctx context.Context, postID int64) (err error) {
 		log.Warn().Err(err).Msg("DeletePost can't execute delete post statement")
 		return
 	}
-	db.ContentStorage.Delete(fmt.Sprintf("%d.%s", postID, p.FileExtension))
-	db.ContentStorage.Delete(fmt.Sprintf("%d.webp", postID))
-	fmt.Sprintf("%d.webp", postID)
+	// Children of a deleted post become children of its parent, or have no
+	// parent if it didn't have one.
+	_, err = db.sqldb.ExecContext(ctx, `update posts set parent = $1 where parent = $2`, p.Parent, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute reparent children statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from favorites where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete favorites statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from votes where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete votes statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from comments where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete comments statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from notes where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete notes statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from note_versions where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete note versions statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from pool_posts where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete pool posts statement")
+		return
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from mass_tag_edit_posts where postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeletePost can't execute delete mass tag edit posts statement")
+		return
+	}
+	if derr := db.ContentStorage.Delete(fmt.Sprintf("%s.%s", p.Filename, p.FileExtension)); derr != nil {
+		log.Warn().Err(derr).Int64("postID", postID).Msg("DeletePost can't delete content file")
+	}
+	if derr := db.ThumbnailsStorage.Delete(fmt.Sprintf("%d.webp", postID)); derr != nil {
+		log.Warn().Err(derr).Int64("postID", postID).Msg("DeletePost can't delete thumbnail")
+	}
 	return
 }
 
@@ -134,51 +205,53 @@ func (db *DB) AllPostIDs(ctx context.Context) ([]int64, error) {
 	return posts, nil
 }
 
-// PostsTagsCounts returns a map of tag to how many time the tag was encountered in all posts
+// postFiles returns the ID, filename and extension of every post. Unlike
+// AllPostIDs it always reads them from the database, so posts added since
+// the search cache was filled are included.
+func (db *DB) postFiles(ctx context.Context) ([]types.Post, error) {
+	defer trace.StartRegion(ctx, "DB/postFiles").End()
+
+	posts := make([]types.Post, 0)
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "postid", "filename", "ext" FROM posts`)
+	if err != nil {
+		log.Error().Err(err).Msg("postFiles can't query posts")
+		return posts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p types.Post
+		if err = rows.Scan(&p.PostID, &p.Filename, &p.FileExtension); err != nil {
+			log.Error().Err(err).Msg("postFiles can't scan row")
+			return posts, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// PostsTagsCounts returns a map of tag to how many of the posts have the tag.
 func (db *DB) PostsTagsCounts(ctx context.Context, posts []int64) (res map[string]int, err error) {
 	defer trace.StartRegion(ctx, "DB/PostsTagsCounts").End()
 
 	res = make(map[string]int)
-	stmt, err := db.sqldb.PrepareContext(ctx, `select "tags" from posts where postID = $1`)
-	defer stmt.Close()
-
-
-
-	for _, p := range posts {
-		var tags string
-		var tagsSlice []string
-
-		if val, ok := postTagsCache.Get(ctx, strconv.Itoa(int(p))); ok {
-			for _, tag := range val.([]string) {
-				if i, ok := res[tag]; ok {
-					res[tag] = i + 1
-				} else {
-					res[tag] = 1
-				}
-			}
-			continue
-		}
-
-		err = stmt.QueryRowContext(ctx, p).Scan(&tags)
-		switch {
-		case err == sql.ErrNoRows:
+	if len(posts) == 0 {
+		return res, nil
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT tag, COUNT(DISTINCT postid) FROM "tagMap" WHERE postid = ANY($1) GROUP BY tag`, pq.Array(posts))
+	if err != nil {
+		log.Error().Err(err).Msg("PostsTagsCounts can't query statement")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		var count int
+		if err = rows.Scan(&tag, &count); err != nil {
 			return
-		case err != nil:
-			log.Fatal().Err(err)
-		default:
-			tagsSlice = utils.SplitTagsString(tags)
-			postTagsCache.Set(ctx, strconv.Itoa(int(p)), tagsSlice, 0)
-			for _, tag := range tagsSlice {
-				if i, ok := res[tag]; ok {
-					res[tag] = i + 1
-				} else {
-					res[tag] = 1
-				}
-			}
 		}
+		res[tag] = count
 	}
-
-	return res, nil
+	return res, rows.Err()
 }
 
 // Posts returns a list of posts from their post IDs, does the same as Post but
@@ -187,14 +260,14 @@ func (db *DB) Posts(ctx context.Context, posts []int64) (res []types.Post, err e
 	defer trace.StartRegion(ctx, "DB/Posts").End()
 
 	res = make([]types.Post, 0)
-	stmt, err := db.sqldb.PrepareContext(ctx, `select "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype" from posts where postID = $1`)
+	stmt, err := db.sqldb.PrepareContext(ctx, `select "filename", "ext", "description", "tags", "poster", "timestamp", "mimetype", "size", "rating", "score", "parent", (SELECT COUNT(*) FROM favorites WHERE favorites.postid = posts.postid) from posts where postID = $1`)
 	defer stmt.Close()
 
 	var tags string
 	var p types.Post
 
 	for _, pid := range posts {
-		err = stmt.QueryRowContext(ctx, pid).Scan(&p.Filename, &p.FileExtension, &p.Description, &tags, &p.Poster, &p.CreatedAt, &p.MimeType)
+		err = stmt.QueryRowContext(ctx, pid).Scan(&p.Filename, &p.FileExtension, &p.Description, &tags, &p.Poster, &p.CreatedAt, &p.MimeType, &p.Size, &p.Rating, &p.Score, &p.Parent, &p.Favorites)
 		switch {
 		case err == sql.ErrNoRows:
 			continue
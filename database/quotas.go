@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/trace"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a upload would take a user over their quota.
+	ErrQuotaExceeded = errors.New("Quota exceeded")
+	// ErrUploadRateLimited is returned when a user has uploaded too many posts recently.
+	ErrUploadRateLimited = errors.New("Too many uploads, try again later")
+)
+
+// UserUsage is how much a user has uploaded.
+type UserUsage struct {
+	// Posts is how many posts the user has.
+	Posts int64
+	// Bytes is the total size of the user's posts.
+	Bytes int64
+	// RecentUploads is how many posts the user uploaded in the last hour.
+	RecentUploads int64
+}
+
+// UserLimits are the limits which apply to a user, 0 meaning no limit.
+type UserLimits struct {
+	// Bytes is how many bytes the user can upload in total.
+	Bytes int64
+	// Posts is how many posts the user can have.
+	Posts int64
+	// UploadsPerHour is how many posts the user can upload in a hour.
+	UploadsPerHour int64
+}
+
+// limit picks a user's override over the default, where a override of 0
+// means to use the default and -1 means no limit.
+func limit(override, def int64) int64 {
+	switch {
+	case override < 0:
+		return 0
+	case override > 0:
+		return override
+	}
+	return def
+}
+
+// UserLimits returns the limits for a user.
+func (db *DB) UserLimits(u types.User) UserLimits {
+	return UserLimits{
+		Bytes:          limit(u.StorageQuota, db.Settings.StorageQuota) * 1024 * 1024,
+		Posts:          limit(u.PostQuota, db.Settings.PostQuota),
+		UploadsPerHour: limit(u.UploadsPerHour, db.Settings.UploadsPerHour),
+	}
+}
+
+// UserUsage returns how much a user has uploaded.
+func (db *DB) UserUsage(ctx context.Context, username string) (usage UserUsage, err error) {
+	defer trace.StartRegion(ctx, "DB/UserUsage").End()
+
+	// CreatedAt is in milliseconds.
+	since := time.Now().Add(-time.Hour).UnixNano() / int64(time.Millisecond)
+	err = db.sqldb.QueryRowContext(ctx, `select count(*), coalesce(sum("size"), 0), count(*) filter (where "timestamp" > $2) from posts where poster = $1`, username, since).Scan(&usage.Posts, &usage.Bytes, &usage.RecentUploads)
+	if err != nil {
+		log.Error().Err(err).Msg("UserUsage can't query statement")
+	}
+	return
+}
+
+// CheckUploadRate returns ErrUploadRateLimited if a user can't upload any
+// more posts right now.
+func (db *DB) CheckUploadRate(ctx context.Context, u types.User) error {
+	limits := db.UserLimits(u)
+	if limits.UploadsPerHour == 0 {
+		return nil
+	}
+	usage, err := db.UserUsage(ctx, u.Username)
+	if err != nil {
+		return err
+	}
+	if usage.RecentUploads >= limits.UploadsPerHour {
+		return ErrUploadRateLimited
+	}
+	return nil
+}
+
+// CheckQuota returns a error wrapping ErrQuotaExceeded if uploading a file
+// of size bytes would take a user over their quota.
+func (db *DB) CheckQuota(ctx context.Context, u types.User, size int64) error {
+	limits := db.UserLimits(u)
+	if limits.Bytes == 0 && limits.Posts == 0 {
+		return nil
+	}
+	usage, err := db.UserUsage(ctx, u.Username)
+	if err != nil {
+		return err
+	}
+	if limits.Posts != 0 && usage.Posts >= limits.Posts {
+		return fmt.Errorf("%w: %d of %d posts used", ErrQuotaExceeded, usage.Posts, limits.Posts)
+	}
+	if limits.Bytes != 0 && usage.Bytes+size > limits.Bytes {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, usage.Bytes, limits.Bytes)
+	}
+	return nil
+}
+
+// backfillPostSizes sets the size of posts uploaded before sizes were tracked.
+func (db *DB) backfillPostSizes() {
+	ctx, task := trace.NewTask(context.Background(), "backfillPostSizes")
+	defer task.End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `select "postid", "filename", "ext" from posts where "size" = 0`)
+	if err != nil {
+		log.Error().Err(err).Msg("backfillPostSizes can't select posts")
+		return
+	}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var postID int64
+		var filename, ext string
+		if err := rows.Scan(&postID, &filename, &ext); err != nil {
+			log.Error().Err(err).Msg("backfillPostSizes can't scan row")
+			rows.Close()
+			return
+		}
+		names[postID] = filename + "." + ext
+	}
+	rows.Close()
+
+	for postID, name := range names {
+		f, err := db.ContentStorage.Open(name)
+		if err != nil {
+			continue
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err != nil || info.Size() == 0 {
+			continue
+		}
+		if _, err := db.sqldb.ExecContext(ctx, `update posts set "size"=$1 where postid = $2`, info.Size(), postID); err != nil {
+			log.Error().Err(err).Msg("backfillPostSizes can't update post")
+			return
+		}
+	}
+	if len(names) != 0 {
+		log.Info().Int("posts", len(names)).Msg("Backfilled post sizes")
+	}
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// ratingTagPrefix is the start of the tag added to every post for its rating,
+// so posts can be searched for by rating.
+const ratingTagPrefix = "rating:"
+
+// DefaultRating returns the rating of posts uploaded without one.
+func (db *DB) DefaultRating() string {
+	if types.ValidRating(db.Settings.DefaultRating) {
+		return db.Settings.DefaultRating
+	}
+	return types.RatingQuestionable
+}
+
+// ViewerRatings returns the ratings shown to a user when searching.
+// A user without a username is a visitor who isn't logged in.
+func (db *DB) ViewerRatings(viewer types.User) []string {
+	if viewer.Username == "" {
+		if len(db.Settings.AnonymousRatings) != 0 {
+			return db.Settings.AnonymousRatings
+		}
+		return []string{types.RatingSafe}
+	}
+	if len(viewer.Ratings) != 0 {
+		return viewer.Ratings
+	}
+	if len(db.Settings.DefaultRatings) != 0 {
+		return db.Settings.DefaultRatings
+	}
+	return []string{types.RatingSafe, types.RatingQuestionable}
+}
+
+// RatingVisible checks if a post with a rating is shown to a user.
+func (db *DB) RatingVisible(viewer types.User, rating string) bool {
+	return sliceContains(db.ViewerRatings(viewer), rating)
+}
+
+// ratingFilter adds a negated rating tag to a search for every rating the
+// viewer doesn't see. Logged in users can still search for hidden ratings by
+// asking for them, but visitors who aren't logged in can't, so false is
+// returned if the search can't match any posts the viewer may see.
+func (db *DB) ratingFilter(viewer types.User, tags []string) ([]string, bool) {
+	visible := db.ViewerRatings(viewer)
+	filtered := make([]string, len(tags))
+	for i, tag := range tags {
+		filtered[i] = strings.ToLower(tag)
+	}
+	for _, rating := range types.Ratings {
+		if sliceContains(visible, rating) {
+			continue
+		}
+		if sliceContains(filtered, ratingTagPrefix+rating) {
+			if viewer.Username == "" {
+				return nil, false
+			}
+			continue
+		}
+		filtered = append(filtered, "-"+ratingTagPrefix+rating)
+	}
+	return filtered, true
+}
+
+// RatingFromTags removes any rating tags from a post's tags, returning the
+// remaining tags and the rating they asked for, or a empty string if none.
+func RatingFromTags(tags []string) ([]string, string) {
+	rating := ""
+	newTags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if strings.HasPrefix(tag, ratingTagPrefix) {
+			if r := strings.TrimPrefix(tag, ratingTagPrefix); types.ValidRating(r) {
+				rating = r
+			}
+			continue
+		}
+		newTags = append(newTags, tag)
+	}
+	return newTags, rating
+}
+
+// migrateRatings gives posts from before ratings existed a rating based on
+// the tags which were used instead, and adds the rating tag to the tag map.
+// It is only run when the rating column is added to the posts table.
+func (db *DB) migrateRatings() {
+	unrated := `postid NOT IN (SELECT postid FROM "tagMap" WHERE tag LIKE 'rating:%')`
+	// Later tags win so posts tagged with more than one get the strictest rating.
+	for _, m := range [][2]string{
+		{"safe", types.RatingSafe},
+		{"questionable", types.RatingQuestionable},
+		{"explicit", types.RatingExplicit},
+	} {
+		_, err := db.sqldb.Exec(`UPDATE posts SET rating = $1 WHERE postid IN (SELECT postid FROM "tagMap" WHERE tag = $2) AND `+unrated, m[1], m[0])
+		if err != nil {
+			log.Warn().Err(err).Msg("SQL Migrate Ratings")
+			return
+		}
+	}
+	_, err := db.sqldb.Exec(`INSERT INTO "tagMap"(postid, tag) SELECT postid, 'rating:' || rating FROM posts WHERE ` + unrated)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Migrate Rating Tags")
+	}
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/NamedKitten/kittehbooru/utils"
-	"github.com/bwmarrin/snowflake"
 )
 
 // paginate paginates a list of int64s
@@ -61,35 +60,28 @@ func (db *DB) searchTag(ctx context.Context, tag string) (posts []int64) {
 func (db *DB) getPostsForTags(ctx context.Context, tags []string) []int64 {
 	defer trace.StartRegion(ctx, "DB/getPostsForTags").End()
 
-	tags = db.filterTags(tags)
+	tags = db.filterTags(ctx, tags)
 
-	tagsPosts, _ := db.TagsPosts(ctx, tags)
-
-	finalPostIDs := make([]int64, 0)
-
-	b := make(map[int64]bool)
-
-	for _, posts := range tagsPosts {
-		for _, post := range posts {
-			b[post] = true
-		}
-	}
-
-	for pid := range b {
-		finalPostIDs = append(finalPostIDs, pid)
-	}
-
-	sort.Slice(finalPostIDs, func(i, j int) bool {
-		return snowflake.ID(finalPostIDs[i]).Time() > snowflake.ID(finalPostIDs[j]).Time()
-	})
+	finalPostIDs, _ := db.TagsPosts(ctx, tags)
 	return finalPostIDs
 }
 
-// TopNCommonTags returns the top N common tags for a search of tags
-func (db *DB) TopNCommonTags(ctx context.Context, n int, tags []string, individualTags bool) []types.TagCounts {
+// TopNCommonTags returns the top N common tags for a search of tags and how
+// many of the results have them, only counting posts with ratings the viewer
+// sees and which don't have any of the viewer's blacklisted tags.
+// With individualTags the tags are a post's tags instead, which are counted
+// across all posts using the counts stored in the tags table.
+func (db *DB) TopNCommonTags(ctx context.Context, viewer types.User, n int, tags []string, individualTags bool) []types.TagCounts {
 	defer trace.StartRegion(ctx, "DB/Top15CommonTags").End()
 
-	combinedTags := utils.TagsListToString(tags)
+	if !individualTags {
+		var ok bool
+		if tags, ok = db.viewerFilter(viewer, normaliseSearchTags(tags)); !ok {
+			return []types.TagCounts{}
+		}
+	}
+
+	combinedTags := searchKey(tags)
 	if val, ok := tagCountsCache.Get(ctx, combinedTags); ok {
 		return val.([]types.TagCounts)
 	}
@@ -101,10 +93,9 @@ func (db *DB) TopNCommonTags(ctx context.Context, n int, tags []string, individu
 		tagCounts, _ = db.PostsTagsCounts(ctx, postsArray)
 	}
 
-
 	tagCountsSlice := make([]types.TagCounts, 0, len(tagCounts))
 	for k, v := range tagCounts {
-		tagCountsSlice = append(tagCountsSlice, types.TagCounts{k, v})
+		tagCountsSlice = append(tagCountsSlice, types.TagCounts{Tag: k, Count: v})
 	}
 
 	sort.Slice(tagCountsSlice, func(i, j int) bool {
@@ -116,22 +107,7 @@ func (db *DB) TopNCommonTags(ctx context.Context, n int, tags []string, individu
 	x := math.Min(float64(n), float64(len(tagCountsSlice)))
 	tagCountsSlice = tagCountsSlice[:int(x)]
 
-	sort.Slice(tagCountsSlice, func(i, j int) bool {
-		if tagCountsSlice[i].Count == tagCountsSlice[j].Count {
-			if strings.HasPrefix(tagCountsSlice[i].Tag, "user:") && strings.HasPrefix(tagCountsSlice[j].Tag, "user:") {
-				return tagCountsSlice[i].Tag < tagCountsSlice[j].Tag
-			} else if strings.HasPrefix(tagCountsSlice[i].Tag, "user:") {
-				return true
-			} else if strings.HasPrefix(tagCountsSlice[j].Tag, "user:") {
-				return false
-			} else {
-				return tagCountsSlice[i].Tag < tagCountsSlice[j].Tag
-			}
-			return tagCountsSlice[i].Tag < tagCountsSlice[j].Tag
-		} else {
-			return tagCountsSlice[i].Count > tagCountsSlice[j].Count
-		}
-	})
+	db.sortTagCounts(ctx, tagCountsSlice)
 
 	tagCountsCache.Set(ctx, combinedTags, tagCountsSlice, 0)
 	return tagCountsSlice
@@ -143,8 +119,8 @@ func (db *DB) cacheSearch(ctx context.Context, searchTags []string) []int64 {
 	defer trace.StartRegion(ctx, "DB/cacheSearch").End()
 
 	var result []int64
-	searchTags = db.filterTags(searchTags)
-	combinedTags := utils.TagsListToString(searchTags)
+	searchTags = db.filterTags(ctx, searchTags)
+	combinedTags := searchKey(searchTags)
 	// If it is in the cache then great! use the cached result
 	// otherise search for them and add to the cache.
 	if val, ok := searchCache.Get(ctx, combinedTags); ok {
@@ -154,18 +130,47 @@ func (db *DB) cacheSearch(ctx context.Context, searchTags []string) []int64 {
 		searchCache.Set(ctx, combinedTags, matching, 0)
 		result = matching
 	}
-	// Sort by posted time
-	// TODO add a parameter to have different sorting modes such as sorting from oldest to newest
-	// or from filesize.
-	sort.Slice(result, func(i, j int) bool {
-		return snowflake.ID(result[i]).Time() > snowflake.ID(result[j]).Time()
-	})
+	// Results are already sorted by TagsPosts using the order: tag.
 	return result
 }
 
-// GetSearchIDs returns a paginated list of Post IDs from a list of tags.
-func (db *DB) GetSearchIDs(ctx context.Context, searchTags []string, page int) ([]int64, int, int) {
+// searchKey returns the cache key of a search, which is its terms sorted and
+// joined without changing them.
+func searchKey(tags []string) string {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "+")
+}
+
+// forgetSearches removes the cached results and tag counts of searches with
+// any of the terms, negated or not, for when something other than a post's
+// tags changes what they match. Terms ending with ":" match any term
+// starting with them.
+func forgetSearches(ctx context.Context, terms ...string) {
+	match := func(k string) bool {
+		for _, t := range utils.SplitTagsString(k) {
+			t = strings.TrimPrefix(t, "-")
+			for _, term := range terms {
+				if t == term || (strings.HasSuffix(term, ":") && strings.HasPrefix(t, term)) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	searchCache.DeleteFunc(ctx, match)
+	tagCountsCache.DeleteFunc(ctx, match)
+}
+
+// GetSearchIDs returns a paginated list of Post IDs from a list of tags,
+// only including posts with ratings the viewer sees and which don't have
+// any of the viewer's blacklisted tags.
+func (db *DB) GetSearchIDs(ctx context.Context, viewer types.User, searchTags []string, page int) ([]int64, int, int) {
 	defer trace.StartRegion(ctx, "DB/GetSearchIDs").End()
+	searchTags, ok := db.viewerFilter(viewer, normaliseSearchTags(searchTags))
+	if !ok {
+		return []int64{}, 0, 0
+	}
 	matching := db.cacheSearch(ctx, searchTags)
 	numPosts := len(matching)
 	numPages := int(math.Ceil(float64(numPosts) / float64(20)))
@@ -173,9 +178,9 @@ func (db *DB) GetSearchIDs(ctx context.Context, searchTags []string, page int) (
 }
 
 // getSearchPage returns a paginated list of posts from a list of tags.
-func (db *DB) GetSearchPage(ctx context.Context, searchTags []string, page int) ([]types.Post, int, int) {
+func (db *DB) GetSearchPage(ctx context.Context, viewer types.User, searchTags []string, page int) ([]types.Post, int, int) {
 	defer trace.StartRegion(ctx, "DB/GetSearchPage").End()
-	pageContent, numPosts, numPages := db.GetSearchIDs(ctx, searchTags, page)
+	pageContent, numPosts, numPages := db.GetSearchIDs(ctx, viewer, searchTags, page)
 	posts, err := db.Posts(ctx, pageContent)
 	if err != nil {
 		return posts, 0, 0
@@ -16,7 +16,9 @@ func (db *DB) sqlInit() {
 	if err != nil {
 		log.Warn().Err(err).Msg("SQL Create Passwords Table")
 	}
-	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "tags" (  "tag"  TEXT, "posts"  TEXT,  PRIMARY KEY("tag"))`)
+	// The old tags table was never used and has a different layout.
+	db.sqldb.Exec(`DO $$ BEGIN IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'tags' AND column_name = 'posts') THEN DROP TABLE "tags"; END IF; END $$`)
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "tags" (  "id" SERIAL PRIMARY KEY, "name" TEXT UNIQUE NOT NULL, "category" TEXT DEFAULT 'general' NOT NULL, "post_count" bigint DEFAULT 0 NOT NULL)`)
 	if err != nil {
 		log.Warn().Err(err).Msg("SQL Create Tags Table")
 	}
@@ -34,5 +36,111 @@ func (db *DB) sqlInit() {
 		log.Warn().Err(err).Msg("SQL Create TagMap Table")
 	}
 
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "favorites" (  "username" TEXT, "postid" bigint, "timestamp" bigint, PRIMARY KEY("username", "postid"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Favorites Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "votes" (  "username" TEXT, "postid" bigint, "value" smallint, "timestamp" bigint, PRIMARY KEY("username", "postid"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Votes Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "comments" (  "id" SERIAL PRIMARY KEY, "postid" bigint, "username" TEXT, "body" TEXT, "timestamp" bigint, "editedAt" bigint DEFAULT 0 NOT NULL)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Comments Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "notes" (  "id" SERIAL PRIMARY KEY, "postid" bigint, "username" TEXT, "x" bigint, "y" bigint, "width" bigint, "height" bigint, "body" TEXT, "version" bigint, "timestamp" bigint, "editedAt" bigint DEFAULT 0 NOT NULL)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Notes Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "note_versions" (  "noteid" bigint, "postid" bigint, "version" bigint, "username" TEXT, "x" bigint, "y" bigint, "width" bigint, "height" bigint, "body" TEXT, "deleted" boolean, "timestamp" bigint, PRIMARY KEY("noteid", "version"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Note Versions Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "pools" (  "id" SERIAL PRIMARY KEY, "name" TEXT, "description" TEXT, "owner" TEXT, "timestamp" bigint, "updatedAt" bigint)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Pools Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "pool_posts" (  "poolid" bigint, "postid" bigint, "position" bigint, PRIMARY KEY("poolid", "postid"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Pool Posts Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "tag_aliases" (  "antecedent" TEXT PRIMARY KEY, "consequent" TEXT, "status" TEXT, "creator" TEXT, "approver" TEXT DEFAULT '' NOT NULL, "timestamp" bigint)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Tag Aliases Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "tag_implications" (  "antecedent" TEXT, "consequent" TEXT, "creator" TEXT, "timestamp" bigint, PRIMARY KEY("antecedent", "consequent"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Tag Implications Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "tag_categories" (  "name" TEXT PRIMARY KEY, "colour" TEXT, "order" bigint)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Tag Categories Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "wiki_pages" (  "tag" TEXT PRIMARY KEY, "body" TEXT, "version" bigint, "username" TEXT, "locked" boolean DEFAULT false NOT NULL, "timestamp" bigint, "updatedAt" bigint)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Wiki Pages Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "wiki_revisions" (  "tag" TEXT, "version" bigint, "username" TEXT, "body" TEXT, "timestamp" bigint, PRIMARY KEY("tag", "version"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Wiki Revisions Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "mass_tag_edits" (  "id" SERIAL PRIMARY KEY, "username" TEXT, "query" TEXT, "add" TEXT, "remove" TEXT, "renameFrom" TEXT, "renameTo" TEXT, "postCount" bigint, "timestamp" bigint, "undoneBy" TEXT DEFAULT '' NOT NULL)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Mass Tag Edits Table")
+	}
+
+	_, err = db.sqldb.Exec(`CREATE TABLE IF NOT EXISTS "mass_tag_edit_posts" (  "editid" bigint, "postid" bigint, "added" TEXT, "removed" TEXT, PRIMARY KEY("editid", "postid"))`)
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Create Mass Tag Edit Posts Table")
+	}
+
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tagMap_tag" ON "tagMap" ("tag")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tagMap_postid" ON "tagMap" ("postid")`)
+	// Autocompletion used to match prefixes of the tag map rather than of tags.
+	db.sqldb.Exec(`DROP INDEX IF EXISTS "tagMap_tag_prefix"`)
+	db.sqldb.Exec(`DROP INDEX IF EXISTS "tagMap_tag_name_prefix"`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tags_name_prefix" ON "tags" ("name" text_pattern_ops)`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tags_name_after_namespace_prefix" ON "tags" ((split_part("name", ':', 2)) text_pattern_ops)`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tags_post_count" ON "tags" ("post_count")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "favorites_postid" ON "favorites" ("postid")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "votes_postid" ON "votes" ("postid", "timestamp")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "comments_postid" ON "comments" ("postid", "id")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "notes_postid" ON "notes" ("postid")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "note_versions_postid" ON "note_versions" ("postid")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "pool_posts_postid" ON "pool_posts" ("postid")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "pool_posts_position" ON "pool_posts" ("poolid", "position")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "wiki_pages_updatedAt" ON "wiki_pages" ("updatedAt")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "mass_tag_edit_posts_postid" ON "mass_tag_edit_posts" ("postid")`)
 	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN theme TEXT DEFAULT 'dark' NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN "storageQuota" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN "postQuota" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN "uploadsPerHour" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE posts ADD COLUMN "size" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "posts_poster" ON posts ("poster", "timestamp")`)
+	// Posts only need ratings migrating from their tags when the column is first added.
+	_, ratingsErr := db.sqldb.Exec(`ALTER TABLE posts ADD COLUMN "rating" TEXT DEFAULT 'q' NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE posts ADD COLUMN "score" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN "ratings" TEXT DEFAULT '' NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE posts ADD COLUMN "parent" bigint DEFAULT 0 NOT NULL`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "posts_parent" ON "posts" ("parent")`)
+	db.sqldb.Exec(`ALTER TABLE users ADD COLUMN "blacklist" TEXT DEFAULT '' NOT NULL`)
+	db.sqldb.Exec(`ALTER TABLE "tagMap" ADD COLUMN "tagid" bigint REFERENCES "tags" ("id")`)
+	db.sqldb.Exec(`CREATE INDEX IF NOT EXISTS "tagMap_tagid" ON "tagMap" ("tagid", "postid")`)
+	if ratingsErr == nil {
+		db.migrateRatings()
+	}
+	db.seedTagCategories()
+	db.migrateTagIDs()
 }
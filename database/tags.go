@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,10 +23,12 @@ func sliceContains(s []string, e string) bool {
 	return false
 }
 
+// removeWildcard removes the * which matches every post, along with
+// terms like ** which are the same.
 func removeWildcard(s []string) []string {
 	tags := make([]string, 0)
 	for _, tag := range s {
-		if tag != "*" {
+		if strings.Trim(tag, "*") != "" {
 			tags = append(tags, tag)
 		}
 	}
@@ -42,187 +45,320 @@ func removeItem(s []string, e string) []string {
 	return tags
 }
 
-// AddPostTags adds a post's tags to the database for easy searching.
+// AddPostTags adds a post's tags to the database for easy searching,
+// replacing any it already had and updating the tags' post counts.
 func (db *DB) AddPostTags(ctx context.Context, post types.Post) error {
 	defer trace.StartRegion(ctx, "DB/addPostTags").End()
-	db.RemovePostTags(ctx, post.PostID)
 
-	tx, err := db.sqldb.Begin()
+	tx, err := db.sqldb.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	stmt, err := tx.Prepare(`INSERT INTO "tagMap"(postid, tag) VALUES( $1, $2 )`)
-	if err != nil {
+	if err = db.addPostTags(ctx, tx, post); err != nil {
 		return err
 	}
-	defer stmt.Close()
-	for _, tag := range post.Tags {
-		if _, err := stmt.Exec(post.PostID, tag); err != nil {
+	return tx.Commit()
+}
+
+// addPostTags replaces a post's tags as part of a transaction.
+func (db *DB) addPostTags(ctx context.Context, tx *sql.Tx, post types.Post) error {
+	if err := removePostTags(ctx, tx, post.PostID); err != nil {
+		return err
+	}
+	tags := post.Tags
+	if post.Rating != "" {
+		tags = append(tags[:len(tags):len(tags)], ratingTagPrefix+post.Rating)
+	}
+	categories := db.TagCategories(ctx)
+	added := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" || sliceContains(added, tag) {
+			continue
+		}
+		if err := addPostTag(ctx, tx, categories, post.PostID, tag); err != nil {
 			return err
 		}
+		added = append(added, tag)
 	}
-	if err := tx.Commit(); err != nil {
+	return nil
+}
+
+// addPostTag adds a tag to a post, creating the tag if it doesn't exist
+// and adding one to its post count. The post mustn't already have the tag.
+func addPostTag(ctx context.Context, tx *sql.Tx, categories []types.TagCategory, postID int64, tag string) error {
+	var tagID int64
+	err := tx.QueryRowContext(ctx, `INSERT INTO tags("name", "category", "post_count") VALUES ($1, $2, 1) ON CONFLICT ("name") DO UPDATE SET "post_count" = tags."post_count" + 1 RETURNING "id"`,
+		tag, tagCategory(categories, tag).Name).Scan(&tagID)
+	if err != nil {
+		log.Warn().Err(err).Msg("addPostTag can't execute insert tag statement")
 		return err
 	}
-	return nil
+	_, err = tx.ExecContext(ctx, `INSERT INTO "tagMap"(postid, tag, tagid) VALUES ($1, $2, $3)`, postID, tag, tagID)
+	if err != nil {
+		log.Warn().Err(err).Msg("addPostTag can't execute insert statement")
+	}
+	return err
+}
+
+// removePostTags removes all of a post's tags, taking one from each of
+// their post counts.
+func removePostTags(ctx context.Context, tx *sql.Tx, postID int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE tags SET "post_count" = "post_count" - 1 WHERE "id" IN (SELECT tagid FROM "tagMap" WHERE postid = $1)`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("removePostTags can't execute update counts statement")
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM "tagMap" WHERE postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("removePostTags can't execute delete statement")
+	}
+	return err
+}
+
+// recountTags sets the post counts of tags from the tag map.
+func recountTags(ctx context.Context, tx *sql.Tx, names ...string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE tags SET "post_count" = (SELECT COUNT(DISTINCT postid) FROM "tagMap" WHERE tagid = tags."id") WHERE "name" = ANY($1)`, pq.Array(names))
+	if err != nil {
+		log.Warn().Err(err).Msg("recountTags can't execute update statement")
+	}
+	return err
+}
+
+// RebuildTags creates any tags missing from the tags table, links every
+// row of the tag map to its tag and recomputes the categories and post
+// counts of all tags, returning how many tags there are.
+func (db *DB) RebuildTags(ctx context.Context) (int, error) {
+	defer trace.StartRegion(ctx, "DB/RebuildTags").End()
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tags("name", "category", "post_count") SELECT DISTINCT tag, $1, 0 FROM "tagMap" WHERE tag IS NOT NULL ON CONFLICT DO NOTHING`, types.GeneralCategory)
+	if err != nil {
+		log.Warn().Err(err).Msg("RebuildTags can't insert missing tags")
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE "tagMap" SET tagid = tags."id" FROM tags WHERE tags."name" = "tagMap".tag AND "tagMap".tagid IS DISTINCT FROM tags."id"`)
+	if err != nil {
+		log.Warn().Err(err).Msg("RebuildTags can't link tag map")
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tags SET "post_count" = COALESCE((SELECT COUNT(DISTINCT postid) FROM "tagMap" WHERE tagid = tags."id"), 0)`)
+	if err != nil {
+		log.Warn().Err(err).Msg("RebuildTags can't recount tags")
+		return 0, err
+	}
+	if err = setTagCategories(ctx, tx); err != nil {
+		return 0, err
+	}
+	var count int
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tags`).Scan(&count); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	tagCountsCache.Flush(ctx)
+	autocompleteCache.Flush(ctx)
+	return count, nil
+}
+
+// migrateTagIDs links the tag map to the tags table after upgrading from
+// before it existed, or after rows were added to the tag map without it.
+func (db *DB) migrateTagIDs() {
+	var unlinked bool
+	err := db.sqldb.QueryRow(`SELECT EXISTS (SELECT 1 FROM "tagMap" WHERE tagid IS NULL)`).Scan(&unlinked)
+	if err != nil || !unlinked {
+		return
+	}
+	n, err := db.RebuildTags(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("SQL Migrate Tag IDs")
+		return
+	}
+	log.Info().Int("tags", n).Msg("SQL Migrate Tag IDs")
 }
 
 // TagPosts returns a list of post IDs for a tag
 func (db *DB) TagPosts(ctx context.Context, tag string) (posts []int64, err error) {
 	defer trace.StartRegion(ctx, "DB/TagPosts").End()
 
-	x, err := db.TagsPosts(ctx, []string{tag})
-	if err == nil {
-		return x[tag], err
-	}
-	return
+	return db.TagsPosts(ctx, []string{tag})
 }
 
 // PostTags returns a list of tags for a post
 func (db *DB) PostTags(ctx context.Context, pid int64) (tagsSlice []string, err error) {
 	defer trace.StartRegion(ctx, "DB/PostTags").End()
 
-	stmt, err := db.sqldb.PrepareContext(ctx, `select "tag" from "tagMap" where postid = $1`)
 	tagsSlice = make([]string, 0)
-	var tag string
-
-	err = stmt.QueryRowContext(ctx, pid).Scan(&tag)
-	switch {
-	case err == sql.ErrNoRows:
+	rows, err := db.sqldb.QueryContext(ctx, `select "tag" from "tagMap" where postid = $1`, pid)
+	if err != nil {
+		log.Error().Err(err).Msg("PostTags can't query statement")
 		return
-	case err != nil:
-		log.Fatal().Err(err)
-	default:
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return
+		}
 		tagsSlice = append(tagsSlice, tag)
 	}
-
+	err = rows.Err()
 	return
 }
 
-
 // RemovePostTags removes all instances of a post from their tags
-func (db *DB) RemovePostTags(ctx context.Context, postID int64) (err error) {
+func (db *DB) RemovePostTags(ctx context.Context, postID int64) error {
 	defer trace.StartRegion(ctx, "DB/RemovePostTags").End()
-	_, err = db.sqldb.ExecContext(ctx, `DELETE FROM "tagMap" WHERE postid = $1`, postID)
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
 	if err != nil {
-		log.Warn().Err(err).Msg("RemovePostTags can't execute delete statement")
-		return
+		return err
 	}
-	return
+	defer tx.Rollback()
+	if err = removePostTags(ctx, tx, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// PostsTagsCounts returns a map of tag to how many time the tag was encountered in tagMap column
+// TagsCounts returns a map of tag to how many posts have the tag.
 func (db *DB) TagsCounts(ctx context.Context, tags []string) (res map[string]int, err error) {
-	defer trace.StartRegion(ctx, "DB/PostsTagsCounts").End()
-
-	res = make(map[string]int)
-	stmt, err := db.sqldb.PrepareContext(ctx, `select COUNT (DISTINCT postid) from "tagMap" where tag = $1`)
-	defer stmt.Close()
+	defer trace.StartRegion(ctx, "DB/TagsCounts").End()
 
+	res = make(map[string]int, len(tags))
 	for _, tag := range tags {
+		res[tag] = 0
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "name", "post_count" FROM tags WHERE "name" = ANY($1)`, pq.Array(tags))
+	if err != nil {
+		log.Error().Err(err).Msg("TagsCounts can't query statement")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
 		var count int
-
-		err = stmt.QueryRowContext(ctx, tag).Scan(&count)
-		switch {
-		case err == sql.ErrNoRows:
-			res[tag] = 0
-		case err != nil:
-			log.Fatal().Err(err)
-		default:
-			res[tag] = count
+		if err = rows.Scan(&name, &count); err != nil {
+			return
 		}
+		res[name] = count
 	}
-
-	return res, nil
+	return res, rows.Err()
 }
 
-// TagPosts returns a map of tags for posts to their post IDs
-func (db *DB) TagsPosts(ctx context.Context, tags []string) (result map[string][]int64, err error) {
-	defer trace.StartRegion(ctx, "DB/TagsPosts").End()
-	result = make(map[string][]int64)
-
-	tags = removeWildcard(tags)
-
-	posTags := make([]string, 0)
-	negTags := make([]string, 0)
-	args := make([]interface{}, 0)
-
-	for _, tag := range tags {
-		if strings.HasPrefix(tag, "-") {
-			negTags = append(negTags, tag[1:])
-		} else {
-			posTags = append(posTags, tag)
+// PopularTags returns the n tags on the most posts the viewer sees,
+// leaving out rating tags. Posts with hidden ratings, blacklisted tags or
+// which are hidden children aren't counted. The post counts stored in the
+// tags table are only used when none of those apply.
+func (db *DB) PopularTags(ctx context.Context, viewer types.User, n int) []types.TagCounts {
+	defer trace.StartRegion(ctx, "DB/PopularTags").End()
+
+	tags, _ := db.viewerFilter(viewer, []string{})
+	key := "popular " + strconv.Itoa(n) + " " + searchKey(tags)
+	if val, ok := tagCountsCache.Get(ctx, key); ok {
+		return val.([]types.TagCounts)
+	}
+	var rows *sql.Rows
+	var err error
+	if len(tags) == 0 {
+		rows, err = db.sqldb.QueryContext(ctx, `SELECT "name", "post_count" FROM tags WHERE "post_count" > 0 AND "name" NOT LIKE 'rating:%' ORDER BY "post_count" DESC, "name" ASC LIMIT $1`, n)
+	} else {
+		q := &searchQuery{}
+		where, _ := db.searchConditions(ctx, q, tags)
+		s := fmt.Sprintf(`SELECT tag, COUNT(DISTINCT postid) AS count FROM "tagMap" WHERE postid IN (SELECT postid FROM posts WHERE %s) AND tag NOT LIKE 'rating:%%' GROUP BY tag ORDER BY count DESC, tag ASC LIMIT %s`, where, q.arg(n))
+		rows, err = db.sqldb.QueryContext(ctx, s, q.args...)
+	}
+	tagCounts := make([]types.TagCounts, 0, n)
+	if err != nil {
+		log.Error().Err(err).Msg("PopularTags can't query statement")
+		return tagCounts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t types.TagCounts
+		if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+			log.Error().Err(err).Msg("PopularTags can't scan row")
+			return tagCounts
 		}
+		tagCounts = append(tagCounts, t)
 	}
+	db.sortTagCounts(ctx, tagCounts)
+	tagCountsCache.Set(ctx, key, tagCounts, 0)
+	return tagCounts
+}
 
-	argsI := 1
-	posArgs := make([]string, 0)
-	negArgs := make([]string, 0)
-
-	// We send args to QueryContext to prevent SQL injection from tags.
-	for _, tag := range posTags {
-		posArgs = append(posArgs, "$"+strconv.Itoa(argsI))
-		args = append(args, tag)
-		argsI = argsI + 1
+// searchConditions returns the SQL condition on posts matching every tag
+// and the ORDER BY clause chosen by the order: tag, or newest first.
+// Tags starting with "-" are posts which must not have the tag, metatags
+// are matched using their own conditions and patterns like cat_* match any
+// tag they match.
+func (db *DB) searchConditions(ctx context.Context, q *searchQuery, tags []string) (string, string) {
+	conds := make([]string, 0, len(tags))
+	orderBy := orders["new"]
+	for _, tag := range removeWildcard(tags) {
+		if strings.HasPrefix(tag, orderPrefix) {
+			if o, ok := orders[strings.TrimPrefix(tag, orderPrefix)]; ok {
+				orderBy = o
+			}
+			continue
+		}
+		negated := strings.HasPrefix(tag, "-")
+		tag = strings.TrimPrefix(tag, "-")
+
+		var cond string
+		if m, value, ok := parseMetatag(tag); ok {
+			cond = m(q, value)
+		} else if isWildcardPattern(tag) && negated {
+			cond = negatedWildcardCondition(q, tag)
+		} else if isWildcardPattern(tag) {
+			cond = db.wildcardCondition(ctx, q, tag)
+		} else {
+			// We send args to QueryContext to prevent SQL injection from tags.
+			cond = `postid IN (SELECT postid FROM "tagMap" WHERE tag = ` + q.arg(tag) + `)`
+		}
+		if negated {
+			cond = "NOT (" + cond + ")"
+		}
+		conds = append(conds, cond)
 	}
-
-	for _, tag := range negTags {
-		negArgs = append(negArgs, "$"+strconv.Itoa(argsI))
-		args = append(args, tag)
-		argsI = argsI + 1
+	if len(conds) == 0 {
+		// If there is no tags provided, assume it's a wildcard search.
+		conds = append(conds, "true")
 	}
+	return strings.Join(conds, " AND "), orderBy
+}
 
-	posArgsStr := strings.Join(posArgs, ",")
-	negArgsStr := strings.Join(negArgs, ",")
-
-	sStart := `SELECT postID, tag FROM "tagMap" WHERE`
-	cond := ""
-	sEnd := `GROUP BY postID, tag`
+// TagsPosts returns the IDs of posts matching every tag as described by
+// searchConditions, sorted by the order: tag or newest first.
+func (db *DB) TagsPosts(ctx context.Context, tags []string) (posts []int64, err error) {
+	defer trace.StartRegion(ctx, "DB/TagsPosts").End()
+	posts = make([]int64, 0)
 
-	if len(negTags) == 0 && len(posTags) == 0 {
-		// If there is no tags provided, assume it's a wildcard search.
-		cond = "true"
-	} else if len(posTags) != 0 && len(negTags) != 0 {
-		// Search using BOTH a positive tag AND and negative tag
-		// include logic for non-matching tag.
-		cond = fmt.Sprintf(`(tag IN (%s)) AND postID NOT IN (SELECT postID FROM "tagMap" WHERE (tag IN (%s)))`, posArgsStr, negArgsStr)
-		// If we didn't group by having count then it'd be a OR of the positive tags
-		// and not a AND
-		sEnd = sEnd + fmt.Sprintf(` HAVING COUNT( postID ) =%d`, len(posTags))
-	} else if len(negTags) != 0 {
-		// Search for posts that don't match tags
-		cond = fmt.Sprintf(`NOT postID IN (SELECT postID FROM "tagMap" WHERE (tag IN (%s)))`, negArgsStr)
-	} else if len(posTags) != 0 {
-		// Search for only positive tags
-		sEnd = sEnd + fmt.Sprintf(` HAVING COUNT( postID ) =%d`, len(posTags))
-		cond = fmt.Sprintf(`(tag IN (%s))`, posArgsStr)
-	}
-
-	s := fmt.Sprintf("%s %s %s", sStart, cond, sEnd)
-
-	rows, err := db.sqldb.QueryContext(ctx, s, args...)
+	q := &searchQuery{}
+	where, orderBy := db.searchConditions(ctx, q, tags)
+	s := fmt.Sprintf(`SELECT postid FROM posts WHERE %s ORDER BY %s`, where, orderBy)
+	rows, err := db.sqldb.QueryContext(ctx, s, q.args...)
 	if err != nil {
 		log.Error().Err(err).Msg("TagsPosts can't query posts")
+		return
 	}
 	defer rows.Close()
 
 	var pid int64
-	var tag string
 	for rows.Next() {
-		err = rows.Scan(&pid, &tag)
+		err = rows.Scan(&pid)
 		if err != nil {
 			log.Error().Err(err).Msg("TagsPosts can't scan row")
 			return
 		}
-
-		if result[tag] == nil {
-			result[tag] = make([]int64, 0)
-		}
-		result[tag] = append(result[tag], pid)
-
+		posts = append(posts, pid)
 	}
-
+	err = rows.Err()
 	return
 }
-
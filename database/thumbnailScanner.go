@@ -16,8 +16,8 @@ func (db *DB) thumbnailScanner() {
 		log.Info().Msg("thumbnailScanner scanning for posts with missing thumbnials.")
 		posts := db.cacheSearch(ctx, []string{"*"})
 		for _, postID := range posts {
-			// If the thumbnail doesn't exist, generate a new thumbnail
-			if f, err := db.ThumbnailsStorage.ReadFile(ctx, fmt.Sprintf("%d.webp", postID)); err != nil {
+			// If the thumbnail doesn't exist or is empty, generate a new thumbnail
+			if state := checkFile(db.ThumbnailsStorage, fmt.Sprintf("%d.webp", postID)); state != fileOK {
 				log.Debug().Int64("postID", postID).Msg("Missing thumbnail, generating new.")
 				p, err := db.Post(ctx, postID)
 				if err != nil {
@@ -25,8 +25,6 @@ func (db *DB) thumbnailScanner() {
 					continue
 				}
 				db.CreateThumbnail(ctx, p)
-			} else {
-				f.Close()
 			}
 		}
 		task.End()
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/NamedKitten/kittehbooru/storage"
 	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/rs/zerolog/log"
 )
@@ -172,7 +173,15 @@ func (db *DB) CreateThumbnail(ctx context.Context, post types.Post) string {
 		log.Error().Err(err).Msg("Cache Create")
 		return ""
 	}
-	io.Copy(newCacheFile, tmpOutputFile)
-	newCacheFile.Close()
+	if _, err = io.Copy(newCacheFile, tmpOutputFile); err != nil {
+		// Don't leave a partial thumbnail behind which would be treated as present.
+		log.Error().Err(err).Msg("Cache Write")
+		storage.Abort(newCacheFile)
+		return ""
+	}
+	if err = newCacheFile.Close(); err != nil {
+		log.Error().Err(err).Msg("Cache Write")
+		return ""
+	}
 	return thumbnailFile
 }
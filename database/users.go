@@ -2,9 +2,8 @@ package database
 
 import (
 	"context"
-	"encoding/json"
-
 	"runtime/trace"
+	"strings"
 
 	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/rs/zerolog/log"
@@ -24,13 +23,16 @@ func (db *DB) AddUser(ctx context.Context, u types.User) {
 func (db *DB) User(ctx context.Context, username string) (u types.User, err error) {
 	defer trace.StartRegion(ctx, "DB/User").End()
 
+	var ratings, blacklist string
 	if val, ok := userCache.Get(ctx, username); ok {
 		u = val.(types.User)
 	} else {
-		err = db.sqldb.QueryRowContext(ctx, `select "avatarID","owner","admin","username","description", "theme" from users where username = $1`, username).Scan(&u.AvatarID, &u.Owner, &u.Admin, &u.Username, &u.Description, &u.Theme)
+		err = db.sqldb.QueryRowContext(ctx, `select "avatarID","owner","admin","username","description", "theme", "storageQuota", "postQuota", "uploadsPerHour", "ratings", "blacklist" from users where username = $1`, username).Scan(&u.AvatarID, &u.Owner, &u.Admin, &u.Username, &u.Description, &u.Theme, &u.StorageQuota, &u.PostQuota, &u.UploadsPerHour, &ratings, &blacklist)
 		if err != nil {
 			log.Error().Err(err).Msg("User can't query statement")
 		} else {
+			u.Ratings = strings.Fields(ratings)
+			u.Blacklist = blacklistFromString(blacklist)
 			userCache.Add(ctx, u.Username, u, 0)
 		}
 	}
@@ -41,7 +43,7 @@ func (db *DB) User(ctx context.Context, username string) (u types.User, err erro
 func (db *DB) EditUser(ctx context.Context, u types.User) (err error) {
 	defer trace.StartRegion(ctx, "DB/EditUser").End()
 	userCache.Delete(ctx, u.Username)
-	_, err = db.sqldb.ExecContext(ctx, `update users set "avatarID"=$1, owner=$2, admin=$3, description=$4, theme=$5 where username = $6`, u.AvatarID, u.Owner, u.Admin, u.Description, u.Theme, u.Username)
+	_, err = db.sqldb.ExecContext(ctx, `update users set "avatarID"=$1, owner=$2, admin=$3, description=$4, theme=$5, "storageQuota"=$6, "postQuota"=$7, "uploadsPerHour"=$8, "ratings"=$9, "blacklist"=$10 where username = $11`, u.AvatarID, u.Owner, u.Admin, u.Description, u.Theme, u.StorageQuota, u.PostQuota, u.UploadsPerHour, strings.Join(u.Ratings, " "), blacklistToString(u.Blacklist), u.Username)
 	if err != nil {
 		log.Warn().Err(err).Msg("EditUser can't execute statement")
 		return err
@@ -66,28 +68,69 @@ func (db *DB) DeleteUser(ctx context.Context, username string) error {
 		return err
 	}
 
+	_, err = db.sqldb.ExecContext(ctx, `delete from favorites where username = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete favorites statement")
+		return err
+	}
+
+	// Take the user's votes away from the scores of the posts they voted on.
+	_, err = db.sqldb.ExecContext(ctx, `update posts set score = score - votes.value from votes where votes.postid = posts.postid and votes.username = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute update scores statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from votes where username = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete votes statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from comments where username = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete comments statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from note_versions where noteid IN (SELECT id FROM notes WHERE username = $1)`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete note versions statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from notes where username = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete notes statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from pool_posts where poolid IN (SELECT id FROM pools WHERE owner = $1)`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete pool posts statement")
+		return err
+	}
+	_, err = db.sqldb.ExecContext(ctx, `delete from pools where owner = $1`, username)
+	if err != nil {
+		log.Warn().Err(err).Msg("DeleteUser can't execute delete pools statement")
+		return err
+	}
+
 	rows, err := db.sqldb.QueryContext(ctx, `select "postid" from posts where poster = $1`, username)
 	if err != nil {
 		log.Error().Err(err).Msg("DeleteUser can't select posts")
+		return err
 	}
 	defer rows.Close()
 
 	var posts []int64
-	var postsString string
+	var pid int64
 
 	for rows.Next() {
-		err = rows.Scan(&postsString)
+		err = rows.Scan(&pid)
 		if err != nil {
 			log.Error().Err(err).Msg("DeleteUser can't scan row")
 			return err
 		}
+		posts = append(posts, pid)
 	}
+	rows.Close()
 
-	err = json.Unmarshal([]byte(postsString), &posts)
-	if err != nil {
-		log.Error().Err(err).Msg("Can't unmarshal posts list")
-		return err
-	}
 	for _, post := range posts {
 		err = db.DeletePost(ctx, post)
 		if err != nil {
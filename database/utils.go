@@ -2,27 +2,34 @@ package database
 
 import (
 	"context"
+	"math"
 	"net/http"
 	"runtime/trace"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/NamedKitten/kittehbooru/types"
 )
 
 // filterTags filters tags before searching using them
 // Order of operations:
+// 0. Normalise the tags and replace aliased tags with the tag they are a alias of
 // 1. Remove duplicate tags
 // 2. Removes both a positive and a negative tag if they are the same.
 // 3. Adds * (wildcard operator) if there is only negative matches.
 // 4. Sorts so positive tags come before negative tags.
 // 5. Sorts so wildcard always comes first.
-func (db *DB) filterTags(tags []string) []string {
+func (db *DB) filterTags(ctx context.Context, tags []string) []string {
+	// 0. Normalise the tags and replace aliased tags with the tag they are a alias of
+	tags = normaliseSearchTags(tags)
+	aliases := db.activeAliases(ctx)
+
 	// 1. Remove duplicate tags
 	tempTags := make(map[string]bool)
 	// this will remove duplicate entrys
 	for _, tag := range tags {
-		tempTags[tag] = true
+		tempTags[applyAlias(aliases, tag)] = true
 	}
 
 	isOnlyNegatives := true
@@ -106,3 +113,14 @@ func (db *DB) CheckForLoggedInUser(ctx context.Context, r *http.Request) (types.
 	}
 	return types.User{}, false
 }
+
+// nowMillis returns the current Unix time in milliseconds, the unit used for
+// timestamps in the database.
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// countPages returns how many pages count items take up.
+func countPages(count int64, perPage int) int {
+	return int(math.Ceil(float64(count) / float64(perPage)))
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/trace"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrInvalidVote is returned when a vote isn't -1, 0 or 1.
+var ErrInvalidVote = errors.New("Invalid vote")
+
+// Vote sets a user's vote on a post to 1 for a up vote, -1 for a down vote
+// or 0 to remove their vote, and updates the post's score.
+func (db *DB) Vote(ctx context.Context, username string, postID int64, value int) error {
+	defer trace.StartRegion(ctx, "DB/Vote").End()
+
+	if value < -1 || value > 1 {
+		return ErrInvalidVote
+	}
+	if _, err := db.Post(ctx, postID); err != nil {
+		return PostNotExistError
+	}
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if value == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE username = $1 AND postid = $2`, username, postID)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO votes("username", "postid", "value", "timestamp") VALUES ($1, $2, $3, $4) ON CONFLICT ("username", "postid") DO UPDATE SET "value" = EXCLUDED."value", "timestamp" = EXCLUDED."timestamp"`, username, postID, value, nowMillis())
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Vote can't execute vote statement")
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE posts SET score = (SELECT COALESCE(SUM("value"), 0) FROM votes WHERE votes.postid = posts.postid) WHERE postid = $1`, postID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Vote can't execute update score statement")
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	forgetSearches(ctx, "score:", orderPrefix+"score", orderPrefix+"score_asc")
+	return nil
+}
+
+// UserVote returns a user's vote on a post, or 0 if they haven't voted.
+func (db *DB) UserVote(ctx context.Context, username string, postID int64) (value int, err error) {
+	defer trace.StartRegion(ctx, "DB/UserVote").End()
+
+	err = db.sqldb.QueryRowContext(ctx, `SELECT COALESCE((SELECT "value" FROM votes WHERE username = $1 AND postid = $2), 0)`, username, postID).Scan(&value)
+	if err != nil {
+		log.Error().Err(err).Msg("UserVote can't query statement")
+	}
+	return
+}
+
+// PopularPosts returns up to limit posts with the highest total of votes
+// made since a time, leaving out posts the viewer doesn't want to see.
+// Like PopularTags the viewer's filters are part of the query, so hidden
+// posts don't take the places of ones the viewer sees.
+func (db *DB) PopularPosts(ctx context.Context, viewer types.User, since time.Time, limit int) ([]types.Post, error) {
+	defer trace.StartRegion(ctx, "DB/PopularPosts").End()
+
+	tags, _ := db.viewerFilter(viewer, []string{})
+	q := &searchQuery{}
+	timestamp := q.arg(since.UnixNano() / int64(time.Millisecond))
+	where, _ := db.searchConditions(ctx, q, tags)
+	s := fmt.Sprintf(`SELECT postid FROM votes WHERE "timestamp" > %s AND postid IN (SELECT postid FROM posts WHERE %s) GROUP BY postid HAVING SUM("value") > 0 ORDER BY SUM("value") DESC, postid DESC LIMIT %s`, timestamp, where, q.arg(limit))
+	rows, err := db.sqldb.QueryContext(ctx, s, q.args...)
+	if err != nil {
+		log.Error().Err(err).Msg("PopularPosts can't query statement")
+		return nil, err
+	}
+	postIDs := make([]int64, 0, limit)
+	for rows.Next() {
+		var pid int64
+		if err := rows.Scan(&pid); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("PopularPosts can't scan row")
+			return nil, err
+		}
+		postIDs = append(postIDs, pid)
+	}
+	rows.Close()
+
+	return db.Posts(ctx, postIDs)
+}
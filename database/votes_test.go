@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+func TestPopularPostsFiltersBeforeLimit(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := NewDB(sqldb, Settings{})
+
+	since := time.Unix(100, 0)
+	viewer := types.User{Username: "kitten", Blacklist: []string{"gore"}}
+	want := `SELECT postid FROM votes WHERE "timestamp" > $1 AND postid IN (SELECT postid FROM posts WHERE NOT (postid IN (SELECT postid FROM "tagMap" WHERE tag = $2)) AND NOT (postid IN (SELECT postid FROM "tagMap" WHERE tag = $3))) GROUP BY postid HAVING SUM("value") > 0 ORDER BY SUM("value") DESC, postid DESC LIMIT $4`
+	mock.ExpectQuery(regexp.QuoteMeta(want)).WithArgs(int64(100000), "rating:e", "gore", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"postid"}))
+	mock.ExpectPrepare(regexp.QuoteMeta(`from posts where postID = $1`))
+
+	if _, err := db.PopularPosts(context.Background(), viewer, since, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/trace"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// wikiPagesPerPage is how many pages are in each page of the wiki page list.
+const wikiPagesPerPage = 50
+
+// maxWikiBodyLength is the longest a wiki page's body can be in bytes.
+const maxWikiBodyLength = 50000
+
+var (
+	// ErrWikiPageNotExist is returned when a tag doesn't have a wiki page.
+	ErrWikiPageNotExist = errors.New("Wiki page does not exist")
+	// ErrInvalidWikiPage is returned when a wiki page isn't for a plain tag or its body is too long.
+	ErrInvalidWikiPage = errors.New("Wiki pages must be for a plain tag and no longer than 50000 characters")
+	// ErrWikiPageLocked is returned when a user who isn't a admin edits a locked wiki page.
+	ErrWikiPageLocked = errors.New("Wiki page is locked")
+)
+
+// wikiTag normalises the tag of a wiki page.
+func wikiTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// WikiTag returns the tag whose wiki page is shown for a tag from a URL,
+// which is normalised and has aliases replaced with the tag they are a
+// alias of.
+func (db *DB) WikiTag(ctx context.Context, tag string) string {
+	return db.AliasedTag(ctx, wikiTag(tag))
+}
+
+// wikiColumns are the columns scanned by scanWikiPages.
+const wikiColumns = `"tag", "body", "version", "username", "locked", "timestamp", "updatedAt"`
+
+// scanWikiPages reads wiki pages from rows selecting wikiColumns.
+func scanWikiPages(rows *sql.Rows) ([]types.WikiPage, error) {
+	defer rows.Close()
+	pages := make([]types.WikiPage, 0)
+	for rows.Next() {
+		var p types.WikiPage
+		if err := rows.Scan(&p.Tag, &p.Body, &p.Version, &p.Username, &p.Locked, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// WikiPage fetches a tag's wiki page.
+func (db *DB) WikiPage(ctx context.Context, tag string) (p types.WikiPage, err error) {
+	defer trace.StartRegion(ctx, "DB/WikiPage").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+wikiColumns+` FROM wiki_pages WHERE "tag" = $1`, wikiTag(tag))
+	if err != nil {
+		log.Error().Err(err).Msg("WikiPage can't query statement")
+		return
+	}
+	pages, err := scanWikiPages(rows)
+	if err != nil {
+		return
+	}
+	if len(pages) == 0 {
+		return p, ErrWikiPageNotExist
+	}
+	return pages[0], nil
+}
+
+// WikiPages returns a page of wiki pages, most recently edited first,
+// along with how many pages of them there are.
+func (db *DB) WikiPages(ctx context.Context, page int) ([]types.WikiPage, int, error) {
+	defer trace.StartRegion(ctx, "DB/WikiPages").End()
+
+	var count int64
+	err := db.sqldb.QueryRowContext(ctx, `SELECT COUNT(*) FROM wiki_pages`).Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("WikiPages can't count pages")
+		return nil, 0, err
+	}
+	if page < 0 {
+		page = 0
+	}
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT `+wikiColumns+` FROM wiki_pages ORDER BY "updatedAt" DESC, "tag" ASC LIMIT $1 OFFSET $2`, wikiPagesPerPage, page*wikiPagesPerPage)
+	if err != nil {
+		log.Error().Err(err).Msg("WikiPages can't query statement")
+		return nil, 0, err
+	}
+	pages, err := scanWikiPages(rows)
+	return pages, countPages(count, wikiPagesPerPage), err
+}
+
+// EditWikiPage changes the body of a tag's wiki page, creating it if it
+// doesn't exist, and records the new body as a revision. Only admins can
+// edit locked pages.
+func (db *DB) EditWikiPage(ctx context.Context, username, tag, body string, admin bool) error {
+	defer trace.StartRegion(ctx, "DB/EditWikiPage").End()
+
+	tag = wikiTag(tag)
+	if !plainTag(tag) || len(body) > maxWikiBodyLength {
+		return ErrInvalidWikiPage
+	}
+
+	tx, err := db.sqldb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The page is locked for the rest of the transaction so it can't be
+	// locked between checking and editing it.
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT "locked" FROM wiki_pages WHERE "tag" = $1 FOR UPDATE`, tag).Scan(&locked)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error().Err(err).Msg("EditWikiPage can't select page")
+		return err
+	}
+	if locked && !admin {
+		return ErrWikiPageLocked
+	}
+
+	now := nowMillis()
+	var version int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO wiki_pages("tag", "body", "version", "username", "locked", "timestamp", "updatedAt") VALUES ($1, $2, 1, $3, false, $4, $4)
+		ON CONFLICT ("tag") DO UPDATE SET "body" = $2, "version" = wiki_pages."version" + 1, "username" = $3, "updatedAt" = $4 RETURNING "version"`,
+		tag, body, username, now).Scan(&version)
+	if err != nil {
+		log.Warn().Err(err).Msg("EditWikiPage can't execute insert statement")
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO wiki_revisions("tag", "version", "username", "body", "timestamp") VALUES ($1, $2, $3, $4, $5)`, tag, version, username, body, now)
+	if err != nil {
+		log.Warn().Err(err).Msg("EditWikiPage can't execute insert revision statement")
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetWikiPageLocked locks or unlocks a wiki page.
+func (db *DB) SetWikiPageLocked(ctx context.Context, tag string, locked bool) error {
+	defer trace.StartRegion(ctx, "DB/SetWikiPageLocked").End()
+
+	res, err := db.sqldb.ExecContext(ctx, `UPDATE wiki_pages SET "locked" = $1 WHERE "tag" = $2`, locked, wikiTag(tag))
+	if err != nil {
+		log.Warn().Err(err).Msg("SetWikiPageLocked can't execute update statement")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWikiPageNotExist
+	}
+	return nil
+}
+
+// WikiRevisions returns every revision of a tag's wiki page, newest first.
+func (db *DB) WikiRevisions(ctx context.Context, tag string) ([]types.WikiRevision, error) {
+	defer trace.StartRegion(ctx, "DB/WikiRevisions").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "tag", "version", "username", "body", "timestamp" FROM wiki_revisions WHERE "tag" = $1 ORDER BY "version" DESC`, wikiTag(tag))
+	if err != nil {
+		log.Error().Err(err).Msg("WikiRevisions can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]types.WikiRevision, 0)
+	for rows.Next() {
+		var rev types.WikiRevision
+		if err := rows.Scan(&rev.Tag, &rev.Version, &rev.Username, &rev.Body, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"runtime/trace"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// maxWildcardTags is the most tags a wildcard search term expands to, the
+// tags on the most posts are used if more match.
+const maxWildcardTags = 100
+
+// isWildcardPattern checks if a search term is a glob pattern like cat_* as
+// opposed to a plain tag or the * which matches every post.
+func isWildcardPattern(tag string) bool {
+	return strings.Contains(tag, "*") && strings.Trim(tag, "*") != ""
+}
+
+// globMatch checks if a tag matches a glob pattern, where * matches
+// any number of characters.
+func globMatch(pattern, tag string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	matched, _ := regexp.MatchString("^"+strings.Join(parts, ".*")+"$", tag)
+	return matched
+}
+
+// anyGlobMatch checks if any of the tags match a glob pattern.
+func anyGlobMatch(pattern string, tags []string) bool {
+	for _, tag := range tags {
+		if globMatch(pattern, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardLike returns the LIKE pattern matching the same tags as a glob pattern.
+func wildcardLike(pattern string) string {
+	return strings.Replace(likeEscaper.Replace(pattern), "*", "%", -1)
+}
+
+// expandWildcard returns the tags matching a glob pattern.
+func (db *DB) expandWildcard(ctx context.Context, pattern string) ([]string, error) {
+	defer trace.StartRegion(ctx, "DB/expandWildcard").End()
+
+	rows, err := db.sqldb.QueryContext(ctx, `SELECT "name" FROM tags WHERE "post_count" > 0 AND "name" LIKE $1 ORDER BY "post_count" DESC, "name" ASC LIMIT $2`, wildcardLike(pattern), maxWildcardTags)
+	if err != nil {
+		log.Error().Err(err).Msg("expandWildcard can't query statement")
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]string, 0)
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// wildcardCondition matches posts with any of the tags matching a glob
+// pattern, which are limited to the maxWildcardTags on the most posts.
+func (db *DB) wildcardCondition(ctx context.Context, q *searchQuery, pattern string) string {
+	tags, err := db.expandWildcard(ctx, pattern)
+	if err != nil || len(tags) == 0 {
+		return "false"
+	}
+	return `postid IN (SELECT postid FROM "tagMap" WHERE tag = ANY(` + q.arg(pq.Array(tags)) + `))`
+}
+
+// negatedWildcardCondition matches posts with any tag matching a glob
+// pattern to be negated. Unlike wildcardCondition it isn't limited to some
+// of the tags, so posts are excluded whichever of the matching tags they have.
+func negatedWildcardCondition(q *searchQuery, pattern string) string {
+	return `postid IN (SELECT postid FROM "tagMap" WHERE tagid IN (SELECT "id" FROM tags WHERE "name" LIKE ` + q.arg(wildcardLike(pattern)) + `))`
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIsWildcardPattern(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"cat", false},
+		{"*", false},
+		{"**", false},
+		{"cat_*", true},
+		{"*_ears", true},
+		{"c*t", true},
+	}
+	for _, test := range tests {
+		if got := isWildcardPattern(test.tag); got != test.want {
+			t.Errorf("isWildcardPattern(%q) = %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, tag string
+		want         bool
+	}{
+		{"cat_*", "cat_ears", true},
+		{"cat_*", "cat_", true},
+		{"cat_*", "cat", false},
+		{"cat_*", "big_cat_ears", false},
+		{"*_ears", "cat_ears", true},
+		{"*_ears", "cat_ears_up", false},
+		{"c*t", "cat", true},
+		{"c*t", "ct", true},
+		{"c*t*s", "cat_ears", true},
+		{"a.c*", "abc", false},
+		{"a.c*", "a.cd", true},
+		{"user:*", "user:kitten", true},
+	}
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.tag); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.tag, got, test.want)
+		}
+	}
+	if !anyGlobMatch("cat_*", []string{"dog", "cat_ears"}) || anyGlobMatch("cat_*", []string{"dog", "cat"}) {
+		t.Error("anyGlobMatch doesn't match when any tag matches")
+	}
+}
+
+func TestWildcardLike(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{"cat*", "cat%"},
+		{"*cat*", "%cat%"},
+		{"cat_*", `cat\_%`},
+		{"100%*", `100\%%`},
+		{`a\b*`, `a\\b%`},
+	}
+	for _, test := range tests {
+		if got := wildcardLike(test.pattern); got != test.want {
+			t.Errorf("wildcardLike(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestNegatedWildcardCoversEveryTag(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := NewDB(sqldb, Settings{})
+
+	// More tags match than a wildcard expands to, so expanding a negated
+	// pattern would leave posts with the rest of them in the results.
+	rows := sqlmock.NewRows([]string{"name"})
+	for i := 0; i < maxWildcardTags+50; i++ {
+		rows.AddRow(fmt.Sprintf("cat_%d", i))
+	}
+	mock.ExpectQuery(`FROM tags WHERE "post_count" > 0 AND "name" LIKE`).WithArgs(`cat\_%`, maxWildcardTags).WillReturnRows(rows)
+
+	q := &searchQuery{}
+	where, _ := db.searchConditions(context.Background(), q, []string{"dog", "-cat_*"})
+	want := `postid IN (SELECT postid FROM "tagMap" WHERE tag = $1) AND NOT (postid IN (SELECT postid FROM "tagMap" WHERE tagid IN (SELECT "id" FROM tags WHERE "name" LIKE $2)))`
+	if where != want {
+		t.Errorf("searchConditions() = %q, want %q", where, want)
+	}
+	if !reflect.DeepEqual(q.args, []interface{}{"dog", `cat\_%`}) {
+		t.Errorf("searchConditions() args = %q", q.args)
+	}
+	if err := mock.ExpectationsWereMet(); err == nil {
+		t.Error("negated wildcard was expanded")
+	}
+}
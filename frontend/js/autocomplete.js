@@ -0,0 +1,126 @@
+// Suggests tags while typing in inputs with a data-autocomplete attribute.
+// The word being typed is the text after the last space or +, and a - in
+// front of it is kept so excluded tags can be completed too.
+(function () {
+  var cache = {};
+
+  function currentWord(input) {
+    var before = input.value.slice(0, input.selectionStart);
+    var start = Math.max(before.lastIndexOf(" "), before.lastIndexOf("+")) + 1;
+    var word = before.slice(start);
+    var negated = word.charAt(0) === "-";
+    return { start: start, end: input.selectionStart, negated: negated, prefix: negated ? word.slice(1) : word };
+  }
+
+  function fetchSuggestions(prefix, callback) {
+    if (cache[prefix]) {
+      callback(cache[prefix]);
+      return;
+    }
+    fetch("/api/v1/tags/autocomplete?q=" + encodeURIComponent(prefix))
+      .then(function (res) { return res.json(); })
+      .then(function (tags) {
+        cache[prefix] = tags;
+        callback(tags);
+      })
+      .catch(function () {});
+  }
+
+  function setup(input) {
+    var list = document.createElement("ul");
+    list.className = "autocomplete";
+    input.parentNode.insertBefore(list, input.nextSibling);
+    var selected = -1;
+    var timer = null;
+
+    function close() {
+      list.innerHTML = "";
+      list.style.display = "none";
+      selected = -1;
+    }
+
+    function choose(tag) {
+      var word = currentWord(input);
+      var replacement = (word.negated ? "-" : "") + tag + " ";
+      input.value = input.value.slice(0, word.start) + replacement + input.value.slice(word.end);
+      var pos = word.start + replacement.length;
+      input.setSelectionRange(pos, pos);
+      input.focus();
+      close();
+    }
+
+    function highlight(i) {
+      var items = list.children;
+      if (items.length === 0) {
+        return;
+      }
+      selected = (i + items.length) % items.length;
+      for (var j = 0; j < items.length; j++) {
+        items[j].classList.toggle("selected", j === selected);
+      }
+    }
+
+    function show(tags) {
+      close();
+      tags.forEach(function (t) {
+        var item = document.createElement("li");
+        var name = document.createElement("span");
+        name.textContent = t.tag;
+        name.style.color = t.colour;
+        var count = document.createElement("small");
+        count.textContent = " " + t.count;
+        item.appendChild(name);
+        item.appendChild(count);
+        item.addEventListener("mousedown", function (e) {
+          e.preventDefault();
+          choose(t.tag);
+        });
+        list.appendChild(item);
+      });
+      if (tags.length !== 0) {
+        list.style.display = "block";
+      }
+    }
+
+    input.addEventListener("input", function () {
+      clearTimeout(timer);
+      var prefix = currentWord(input).prefix;
+      if (prefix.length === 0) {
+        close();
+        return;
+      }
+      timer = setTimeout(function () {
+        fetchSuggestions(prefix.toLowerCase(), function (tags) {
+          if (currentWord(input).prefix === prefix) {
+            show(tags);
+          }
+        });
+      }, 150);
+    });
+
+    input.addEventListener("keydown", function (e) {
+      if (list.children.length === 0) {
+        return;
+      }
+      if (e.key === "ArrowDown") {
+        e.preventDefault();
+        highlight(selected + 1);
+      } else if (e.key === "ArrowUp") {
+        e.preventDefault();
+        highlight(selected - 1);
+      } else if ((e.key === "Enter" || e.key === "Tab") && selected !== -1) {
+        e.preventDefault();
+        choose(list.children[selected].firstChild.textContent);
+      } else if (e.key === "Escape") {
+        close();
+      }
+    });
+
+    input.addEventListener("blur", close);
+    close();
+  }
+
+  document.addEventListener("DOMContentLoaded", function () {
+    document.querySelectorAll("input[data-autocomplete]").forEach(setup);
+  });
+})();
@@ -0,0 +1,56 @@
+// Positions notes over the post's image. Notes are stored in pixels of the
+// original image so they're scaled by how big the image is displayed.
+function layoutNotes() {
+  var img = document.querySelector(".note-wrapper img");
+  if (!img || !img.naturalWidth) {
+    return;
+  }
+  var scale = img.clientWidth / img.naturalWidth;
+  document.querySelectorAll(".note-box").forEach(function (note) {
+    note.style.left = img.offsetLeft + note.dataset.x * scale + "px";
+    note.style.top = img.offsetTop + note.dataset.y * scale + "px";
+    note.style.width = note.dataset.width * scale + "px";
+    note.style.height = note.dataset.height * scale + "px";
+    note.style.display = "block";
+  });
+}
+
+// Lets a region be dragged out on the image to fill in the new note form.
+function setupNoteDrawing() {
+  var img = document.querySelector(".note-wrapper img");
+  var form = document.getElementById("new-note");
+  if (!img || !form) {
+    return;
+  }
+  var start = null;
+  function toImage(e) {
+    var rect = img.getBoundingClientRect();
+    var scale = img.naturalWidth / img.clientWidth;
+    return {
+      x: Math.max(0, Math.round((e.clientX - rect.left) * scale)),
+      y: Math.max(0, Math.round((e.clientY - rect.top) * scale)),
+    };
+  }
+  img.addEventListener("mousedown", function (e) {
+    e.preventDefault();
+    start = toImage(e);
+  });
+  img.addEventListener("mouseup", function (e) {
+    if (!start) {
+      return;
+    }
+    var end = toImage(e);
+    form.x.value = Math.min(start.x, end.x);
+    form.y.value = Math.min(start.y, end.y);
+    form.width.value = Math.max(1, Math.abs(end.x - start.x));
+    form.height.value = Math.max(1, Math.abs(end.y - start.y));
+    start = null;
+    form.body.focus();
+  });
+}
+
+window.addEventListener("load", function () {
+  layoutNotes();
+  setupNoteDrawing();
+});
+window.addEventListener("resize", layoutNotes);
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// AliasesTemplate contains data to be used in the template.
+type AliasesTemplate struct {
+	Aliases []types.TagAlias
+	templates.T
+}
+
+// AliasesPageHandler shows the admin page for tag aliases.
+func AliasesPageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+	aliases, err := DB.TagAliases(ctx)
+	if err != nil {
+		renderError(w, "ALIASES_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "aliases.html", AliasesTemplate{
+		Aliases: aliases,
+		T: templates.T{
+			LoggedIn:     true,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// AliasesHandler proposes, approves or removes a tag alias.
+func AliasesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	antecedent := r.PostFormValue("antecedent")
+	switch r.PostFormValue("action") {
+	case "propose":
+		err = DB.ProposeAlias(ctx, user.Username, antecedent, r.PostFormValue("consequent"))
+	case "approve":
+		var n int
+		n, err = DB.ApproveAlias(ctx, user.Username, antecedent)
+		log.Info().Str("username", user.Username).Str("tag", antecedent).Int("posts", n).Msg("Approved alias")
+	case "remove":
+		err = DB.RemoveAlias(ctx, antecedent)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Aliases")
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrInvalidAlias) || errors.Is(err, database.ErrAliasExists) ||
+			errors.Is(err, database.ErrAliasChain) || errors.Is(err, database.ErrAliasNotExist) {
+			status = http.StatusBadRequest
+		}
+		renderError(w, "ALIAS_ERR", err, status)
+		return
+	}
+	http.Redirect(w, r, "/admin/aliases", http.StatusFound)
+}
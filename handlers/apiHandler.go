@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// apiError is the body of a API response for a failed request.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// APISearchResults is the body of a API search response.
+type APISearchResults struct {
+	Posts    []types.Post `json:"posts"`
+	NumPosts int          `json:"numPosts"`
+	NumPages int          `json:"numPages"`
+}
+
+// writeJSON writes a value as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("Can't write JSON response")
+	}
+}
+
+// APIPostHandler returns a post as JSON.
+func APIPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil || (!loggedIn && !DB.RatingVisible(user, post.Rating)) {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APISearchHandler returns a page of posts matching a search as JSON.
+func APISearchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, _ := DB.CheckForLoggedInUser(ctx, r)
+	tagsStr := r.URL.Query().Get("tags")
+	if len(tagsStr) == 0 {
+		tagsStr = "*"
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+	posts, numPosts, numPages := DB.GetSearchPage(ctx, user, utils.SplitTagsString(tagsStr), page)
+	writeJSON(w, http.StatusOK, APISearchResults{
+		Posts:    posts,
+		NumPosts: numPosts,
+		NumPages: numPages,
+	})
+}
+
+// APIFavoriteHandler favorites a post with POST and unfavorites it with DELETE.
+func APIFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+		return
+	}
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err = DB.RemoveFavorite(ctx, user.Username, postID)
+	} else {
+		err = DB.AddFavorite(ctx, user.Username, postID)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APIVoteHandler sets the logged in user's vote on a post to the value
+// parameter, which is 1, -1 or 0 to remove the vote.
+func APIVoteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+		return
+	}
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	value, err := strconv.Atoi(r.FormValue("value"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_VOTE"})
+		return
+	}
+	if err = DB.Vote(ctx, user.Username, postID, value); err != nil {
+		if err == database.ErrInvalidVote {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_VOTE"})
+		} else {
+			writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		}
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APICommentsResults is the body of a API response for a page of comments.
+type APICommentsResults struct {
+	Comments []types.Comment `json:"comments"`
+	NumPages int             `json:"numPages"`
+}
+
+// APIPostCommentsHandler returns a page of a post's comments with GET and
+// adds a comment from the body parameter with POST.
+func APIPostCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil || (!loggedIn && !DB.RatingVisible(user, post.Rating)) {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !loggedIn {
+			writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+			return
+		}
+		id, err := DB.AddComment(ctx, user.Username, postID, r.FormValue("body"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_COMMENT"})
+			return
+		}
+		c, err := DB.Comment(ctx, id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"COMMENT_ERR"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, c)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+	comments, numPages, err := DB.PostComments(ctx, postID, page)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"COMMENTS_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, APICommentsResults{Comments: comments, NumPages: numPages})
+}
+
+// APICommentHandler returns a comment with GET, edits it from the body
+// parameter with PATCH and deletes it with DELETE.
+func APICommentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	id, err := strconv.ParseInt(mux.Vars(r)["commentID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_COMMENT_ID"})
+		return
+	}
+	c, err := DB.Comment(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"COMMENT_NOT_FOUND"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, c)
+		return
+	}
+	if !canEditComment(user, loggedIn, c) {
+		writeJSON(w, http.StatusForbidden, apiError{"NO_PERMISSIONS"})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := DB.DeleteComment(ctx, id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"COMMENT_ERR"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := DB.EditComment(ctx, id, r.FormValue("body")); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_COMMENT"})
+		return
+	}
+	c, err = DB.Comment(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"COMMENT_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// APIPostNotesHandler returns a post's notes with GET and adds a note from
+// the x, y, width, height and body parameters with POST.
+func APIPostNotesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil || (!loggedIn && !DB.RatingVisible(user, post.Rating)) {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !loggedIn {
+			writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+			return
+		}
+		rect, err := noteRectFromRequest(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_NOTE"})
+			return
+		}
+		id, err := DB.AddNote(ctx, user.Username, postID, rect, r.FormValue("body"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_NOTE"})
+			return
+		}
+		n, err := DB.Note(ctx, id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"NOTE_ERR"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, n)
+		return
+	}
+
+	notes, err := DB.PostNotes(ctx, postID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"NOTES_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, notes)
+}
+
+// APIPostNoteHistoryHandler returns every version of the notes on a post.
+func APIPostNoteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil || (!loggedIn && !DB.RatingVisible(user, post.Rating)) {
+		writeJSON(w, http.StatusNotFound, apiError{"POST_NOT_FOUND"})
+		return
+	}
+	versions, err := DB.PostNoteVersions(ctx, postID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"NOTES_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// APINoteHandler returns a note with GET, edits it from the x, y, width,
+// height and body parameters with PATCH and deletes it with DELETE.
+func APINoteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	id, err := strconv.ParseInt(mux.Vars(r)["noteID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_NOTE_ID"})
+		return
+	}
+	n, err := DB.Note(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"NOTE_NOT_FOUND"})
+		return
+	}
+	post, err := DB.Post(ctx, n.PostID)
+	if err != nil || (!loggedIn && !DB.RatingVisible(user, post.Rating)) {
+		writeJSON(w, http.StatusNotFound, apiError{"NOTE_NOT_FOUND"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, n)
+		return
+	}
+	if !canEditNote(user, loggedIn, n, post) {
+		writeJSON(w, http.StatusForbidden, apiError{"NO_PERMISSIONS"})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := DB.DeleteNote(ctx, user.Username, id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"NOTE_ERR"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	rect, err := noteRectFromRequest(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_NOTE"})
+		return
+	}
+	if err := DB.EditNote(ctx, user.Username, id, rect, r.FormValue("body")); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_NOTE"})
+		return
+	}
+	n, err = DB.Note(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"NOTE_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+// APIPoolsResults is the body of a API response for a page of pools.
+type APIPoolsResults struct {
+	Pools    []types.Pool `json:"pools"`
+	NumPages int          `json:"numPages"`
+}
+
+// APIPoolsHandler returns a page of pools with GET and creates a pool from
+// the name and description parameters with POST.
+func APIPoolsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if r.Method == http.MethodPost {
+		if !loggedIn {
+			writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+			return
+		}
+		id, err := DB.CreatePool(ctx, user.Username, r.FormValue("name"), r.FormValue("description"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POOL"})
+			return
+		}
+		pool, err := DB.Pool(ctx, id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"POOL_ERR"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, pool)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil {
+		page = 0
+	}
+	pools, numPages, err := DB.Pools(ctx, page)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"POOLS_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, APIPoolsResults{Pools: pools, NumPages: numPages})
+}
+
+// APIPoolHandler returns a pool with its posts in order with GET, changes
+// it with PATCH and deletes it with DELETE. PATCH takes name, description
+// and posts, a whitespace separated list of post IDs in order, and leaves
+// out parameters unchanged.
+func APIPoolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	id, err := strconv.ParseInt(mux.Vars(r)["poolID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POOL_ID"})
+		return
+	}
+	pool, err := DB.Pool(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"POOL_NOT_FOUND"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, pool)
+		return
+	}
+	if !canEditPool(user, loggedIn, pool) {
+		writeJSON(w, http.StatusForbidden, apiError{"NO_PERMISSIONS"})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := DB.DeletePool(ctx, id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"POOL_ERR"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	r.ParseForm()
+	name, description := pool.Name, pool.Description
+	if _, ok := r.Form["name"]; ok {
+		name = r.FormValue("name")
+	}
+	if _, ok := r.Form["description"]; ok {
+		description = r.FormValue("description")
+	}
+	if err := DB.EditPool(ctx, id, name, description); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POOL"})
+		return
+	}
+	if _, ok := r.Form["posts"]; ok {
+		postIDs, err := parsePostIDs(r.FormValue("posts"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+			return
+		}
+		if err := DB.SetPoolPosts(ctx, id, postIDs); err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{"POOL_ERR"})
+			return
+		}
+	}
+	pool, err = DB.Pool(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"POOL_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, pool)
+}
+
+// APIPoolPostHandler adds a post to the end of a pool with POST and
+// removes it with DELETE.
+func APIPoolPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		writeJSON(w, http.StatusUnauthorized, apiError{"NOT_LOGGED_IN"})
+		return
+	}
+	id, err := strconv.ParseInt(vars["poolID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POOL_ID"})
+		return
+	}
+	postID, err := strconv.ParseInt(vars["postID"], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"INVALID_POST_ID"})
+		return
+	}
+	pool, err := DB.Pool(ctx, id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{"POOL_NOT_FOUND"})
+		return
+	}
+	if !canEditPool(user, loggedIn, pool) {
+		writeJSON(w, http.StatusForbidden, apiError{"NO_PERMISSIONS"})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = DB.RemovePoolPost(ctx, id, postID)
+	} else {
+		err = DB.AddPoolPost(ctx, id, postID)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"POOL_ERR"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIWikiHandler returns a tag's wiki page.
+func APIWikiHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := DB.WikiPage(r.Context(), mux.Vars(r)["tag"])
+	if err == database.ErrWikiPageNotExist {
+		writeJSON(w, http.StatusNotFound, apiError{"WIKI_PAGE_NOT_FOUND"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"WIKI_ERR"})
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// APITagSuggestion is a tag in a API autocomplete response.
+type APITagSuggestion struct {
+	Tag      string `json:"tag"`
+	Count    int    `json:"count"`
+	Category string `json:"category"`
+	Colour   string `json:"colour"`
+}
+
+// APITagAutocompleteHandler returns the most used tags starting with q,
+// or with q after their namespace, for suggesting tags while typing.
+func APITagAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+	tags, err := DB.AutocompleteTags(ctx, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{"AUTOCOMPLETE_ERR"})
+		return
+	}
+	suggestions := make([]APITagSuggestion, len(tags))
+	for i, t := range tags {
+		c := DB.TagCategory(ctx, t.Tag)
+		suggestions[i] = APITagSuggestion{Tag: t.Tag, Count: t.Count, Category: c.Name, Colour: c.Colour}
+	}
+	writeJSON(w, http.StatusOK, suggestions)
+}
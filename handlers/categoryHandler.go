@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// CategoriesTemplate contains data to be used in the template.
+type CategoriesTemplate struct {
+	Categories []types.TagCategory
+	templates.T
+}
+
+// CategoriesPageHandler shows the admin page for tag categories.
+func CategoriesPageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := templates.RenderTemplate(w, "categories.html", CategoriesTemplate{
+		Categories: DB.TagCategories(r.Context()),
+		T: templates.T{
+			LoggedIn:     true,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// CategoriesHandler adds, changes or removes a tag category.
+func CategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, ok := adminFromRequest(w, r); !ok {
+		return
+	}
+
+	var err error
+	name := r.PostFormValue("name")
+	switch r.PostFormValue("action") {
+	case "set":
+		var order int
+		order, err = strconv.Atoi(r.PostFormValue("order"))
+		if err != nil {
+			renderError(w, "INVALID_ORDER", err, http.StatusBadRequest)
+			return
+		}
+		err = DB.SetTagCategory(ctx, types.TagCategory{Name: name, Colour: r.PostFormValue("colour"), Order: order})
+	case "remove":
+		err = DB.RemoveTagCategory(ctx, name)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Categories")
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrInvalidCategory) || errors.Is(err, database.ErrCategoryRequired) {
+			status = http.StatusBadRequest
+		}
+		renderError(w, "CATEGORY_ERR", err, status)
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusFound)
+}
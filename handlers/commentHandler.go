@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// canEditComment checks if a user can edit or delete a comment, which like
+// posts is only the author or a admin.
+func canEditComment(user types.User, loggedIn bool, c types.Comment) bool {
+	return loggedIn && (user.Admin || c.Username == user.Username)
+}
+
+// commentURL returns the URL of a comment on its post's page.
+func commentURL(c types.Comment) string {
+	return "/view/" + strconv.FormatInt(c.PostID, 10) + "#comment-" + strconv.FormatInt(c.ID, 10)
+}
+
+// CommentHandler is the endpoint used to comment on a post.
+func CommentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(vars["postID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	id, err := DB.AddComment(ctx, user.Username, postID, r.PostFormValue("body"))
+	if err != nil {
+		log.Error().Err(err).Msg("Add Comment")
+		renderError(w, "COMMENT_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, commentURL(types.Comment{ID: id, PostID: postID}), http.StatusFound)
+}
+
+// commentFromRequest fetches the comment in the URL and checks the logged
+// in user can change it, rendering a error if not.
+func commentFromRequest(w http.ResponseWriter, r *http.Request) (types.Comment, bool) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return types.Comment{}, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["commentID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_COMMENT_ID", err, http.StatusBadRequest)
+		return types.Comment{}, false
+	}
+	c, err := DB.Comment(ctx, id)
+	if err != nil {
+		renderError(w, "COMMENT_NOT_FOUND", err, http.StatusNotFound)
+		return types.Comment{}, false
+	}
+	if !canEditComment(user, loggedIn, c) {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return types.Comment{}, false
+	}
+	return c, true
+}
+
+// EditCommentHandler is the endpoint used to edit a comment.
+func EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := DB.EditComment(r.Context(), c.ID, r.PostFormValue("body")); err != nil {
+		log.Error().Err(err).Msg("Edit Comment")
+		renderError(w, "COMMENT_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, commentURL(c), http.StatusFound)
+}
+
+// DeleteCommentHandler is the endpoint used to delete a comment.
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := DB.DeleteComment(r.Context(), c.ID); err != nil {
+		log.Error().Err(err).Msg("Delete Comment")
+		renderError(w, "COMMENT_ERR", err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/view/"+strconv.FormatInt(c.PostID, 10), http.StatusFound)
+}
+
+// RecentCommentsTemplate contains data to be used in the template.
+type RecentCommentsTemplate struct {
+	Comments   []types.Comment
+	Page       int
+	TotalPages int
+	templates.T
+}
+
+// RecentCommentsHandler shows the newest comments on all posts.
+func RecentCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	comments, numPages, err := DB.RecentComments(ctx, user, page)
+	if err != nil {
+		renderError(w, "COMMENTS_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "comments.html", RecentCommentsTemplate{
+		Comments:   comments,
+		Page:       page,
+		TotalPages: numPages,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
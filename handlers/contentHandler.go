@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/gorilla/mux"
+)
+
+// canViewPostFiles checks if the requester may fetch a post's files, which
+// like the view page is any logged in user, while visitors only get the
+// ratings allowed for them.
+func canViewPostFiles(r *http.Request, post types.Post) bool {
+	if DB.RatingVisible(types.User{}, post.Rating) {
+		return true
+	}
+	_, loggedIn := DB.CheckForLoggedInUser(r.Context(), r)
+	return loggedIn
+}
+
+// postFileCacheControl returns the Cache-Control header for a post's files,
+// which can't be stored by shared caches if visitors can't see the post.
+func postFileCacheControl(post types.Post, cacheControl string) string {
+	if !DB.RatingVisible(types.User{}, post.Rating) {
+		return strings.Replace(cacheControl, "public", "private", 1)
+	}
+	return cacheControl
+}
+
+// ContentHandler serves the content file of a post.
+func ContentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	filename := vars["filename"]
+	if !DB.VerifySignedURL("content", filename, r.URL.Query()) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	postID, err := strconv.ParseInt(strings.SplitN(filename, ".", 2)[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil || filename != post.Filename+"."+post.FileExtension {
+		http.NotFound(w, r)
+		return
+	}
+	if !canViewPostFiles(r, post) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// Content never changes once uploaded.
+	setFileCacheControl(w, postFileCacheControl(post, "public, immutable, max-age=2592000"))
+	serveFile(w, r, DB.ContentStorage, filename, post.MimeType, time.Unix(0, post.CreatedAt*int64(time.Millisecond)))
+}
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/NamedKitten/kittehbooru/utils"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -44,7 +46,22 @@ func EditPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tags := utils.SplitTagsString(r.PostFormValue("tags"))
+	tags, tagRating := database.RatingFromTags(utils.SplitTagsString(r.PostFormValue("tags")))
+	if rating := r.PostFormValue("rating"); types.ValidRating(rating) {
+		post.Rating = rating
+	} else if tagRating != "" {
+		post.Rating = tagRating
+	}
+
+	tags, tagParent := database.ParentFromTags(tags)
+	post.Parent, err = parentFromRequest(r, tagParent)
+	if err == nil {
+		err = DB.CheckParent(ctx, post.PostID, post.Parent)
+	}
+	if err != nil {
+		renderError(w, "INVALID_PARENT", err, http.StatusBadRequest)
+		return
+	}
 
 	newTags := make([]string, 0)
 	for _, tag := range tags {
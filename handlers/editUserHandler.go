@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 )
@@ -66,6 +69,47 @@ func EditUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if _, ok := r.PostForm["blacklist"]; ok {
+		user.Blacklist = strings.Fields(r.PostFormValue("blacklist"))
+	}
+
+	// The ratings checkboxes send nothing when none are ticked, so the form
+	// includes ratingsSet to tell that apart from a form without them.
+	if len(r.PostFormValue("ratingsSet")) != 0 {
+		ratings := make([]string, 0)
+		for _, rating := range r.PostForm["ratings"] {
+			if types.ValidRating(rating) {
+				ratings = append(ratings, rating)
+			}
+		}
+		// A empty list means the default ratings, so showing none can't be saved.
+		if len(ratings) == 0 {
+			renderError(w, "INVALID_RATINGS", errors.New("At least one rating must be shown"), http.StatusBadRequest)
+			return
+		}
+		user.Ratings = ratings
+	}
+
+	if loggedInUser.Admin {
+		quotas := map[string]*int64{
+			"storageQuota":   &user.StorageQuota,
+			"postQuota":      &user.PostQuota,
+			"uploadsPerHour": &user.UploadsPerHour,
+		}
+		for name, value := range quotas {
+			v := r.PostFormValue(name)
+			if len(v) == 0 {
+				continue
+			}
+			quota, qerr := strconv.ParseInt(v, 10, 64)
+			if qerr != nil || quota < -1 {
+				renderError(w, "INVALID_QUOTA", errors.New("Invalid "+name), http.StatusBadRequest)
+				return
+			}
+			*value = quota
+		}
+	}
+
 	avatarID := r.PostFormValue("avatarID")
 	if !(len(avatarID) == 0) {
 		avatarIDInt, aerr := strconv.Atoi(avatarID)
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/rs/zerolog/log"
+)
+
+// ExportHandler streams a backup archive of the whole instance to an admin.
+// Password hashes are only included for the owner when asked for.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if !user.Admin {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return
+	}
+
+	opts := database.ExportOptions{
+		Passwords: user.Owner && r.URL.Query().Get("passwords") == "1",
+	}
+
+	filename := fmt.Sprintf("kittehbooru-%s.tar.gz", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	log.Info().Str("username", user.Username).Bool("passwords", opts.Passwords).Msg("Export")
+	err := DB.Export(ctx, w, opts)
+	if err != nil {
+		// Headers have already been sent so we can only log the error.
+		log.Error().Err(err).Msg("Export")
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// FavoriteHandler is the endpoint used to favorite or unfavorite a post.
+func FavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(vars["postID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+
+	if r.PostFormValue("action") == "remove" {
+		err = DB.RemoveFavorite(ctx, user.Username, postID)
+	} else {
+		err = DB.AddFavorite(ctx, user.Username, postID)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Favorite")
+		renderError(w, "FAVORITE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/view/"+vars["postID"], http.StatusFound)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileURLKind works out which kind of file a path points to using the
+// content and thumbnail URLs, returning the kind and filename. The longest
+// matching URL wins so one can be inside the other.
+func fileURLKind(p string) (kind string, name string, ok bool) {
+	prefixes := []struct{ kind, path string }{
+		{"content", ""},
+		{"thumbnail", ""},
+	}
+	for i, prefix := range []string{DB.Settings.ContentURL, DB.Settings.ThumbnailURL} {
+		if u, err := url.Parse(prefix); err == nil {
+			prefixes[i].path = u.Path
+		}
+	}
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return len(prefixes[i].path) > len(prefixes[j].path)
+	})
+	for _, prefix := range prefixes {
+		if prefix.path == "" || !strings.HasPrefix(p, prefix.path) {
+			continue
+		}
+		name = strings.TrimPrefix(p, prefix.path)
+		if name != "" && !strings.Contains(name, "/") {
+			return prefix.kind, name, true
+		}
+		return "", "", false
+	}
+	return "", "", false
+}
+
+// FileAuthHandler checks the signature of a content or thumbnail URL for a
+// reverse proxy serving files itself, such as nginx's auth_request, and that
+// the requester may see the post like when the files are served directly.
+// The URL is read from the X-Original-URI or X-Forwarded-Uri header.
+func FileAuthHandler(w http.ResponseWriter, r *http.Request) {
+	original := r.Header.Get("X-Original-URI")
+	if original == "" {
+		original = r.Header.Get("X-Forwarded-Uri")
+	}
+	u, err := url.ParseRequestURI(original)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	kind, name, ok := fileURLKind(u.Path)
+	if !ok || !DB.VerifySignedURL(kind, name, u.Query()) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	postID, err := strconv.ParseInt(strings.SplitN(name, ".", 2)[0], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	post, err := DB.Post(r.Context(), postID)
+	if err != nil || (kind == "content" && name != post.Filename+"."+post.FileExtension) || !canViewPostFiles(r, post) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
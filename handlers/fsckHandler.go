@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/rs/zerolog/log"
+)
+
+type FsckTemplate struct {
+	Report database.FsckReport
+	templates.T
+}
+
+// FsckPageHandler shows a admin report of missing, broken and orphaned files.
+func FsckPageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if !user.Admin {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return
+	}
+
+	report, err := DB.Fsck(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Fsck")
+		renderError(w, "FSCK_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	templateInfo := FsckTemplate{
+		Report: report,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	}
+
+	err = templates.RenderTemplate(w, "fsck.html", templateInfo)
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// FsckHandler repairs problems found by the storage check.
+func FsckHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if !user.Admin {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return
+	}
+
+	report, err := DB.Fsck(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Fsck")
+		renderError(w, "FSCK_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.PostFormValue("action") {
+	case "regenerate":
+		DB.QueueThumbnails(report.BrokenThumbnails())
+	case "deleteOrphans":
+		log.Info().Str("username", user.Username).Msg("Deleting orphaned files")
+		err = DB.DeleteFiles(ctx, DB.ContentStorage, report.OrphanContent)
+		if terr := DB.DeleteFiles(ctx, DB.ThumbnailsStorage, report.OrphanThumbnails); err == nil {
+			err = terr
+		}
+		if err != nil {
+			renderError(w, "CANT_DELETE_FILE", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/admin/fsck", http.StatusFound)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/NamedKitten/kittehbooru/database"
 	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -15,7 +16,7 @@ var DB *database.DB
 var NoPermissionsError = errors.New("No Permissions")
 
 func renderError(w http.ResponseWriter, message string, e error, statusCode int) {
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(statusCode)
 
 	errorString := fmt.Sprintln(e)
 	err := templates.RenderTemplate(w, "error.html", message+": "+errorString)
@@ -24,3 +25,18 @@ func renderError(w http.ResponseWriter, message string, e error, statusCode int)
 		log.Error().Err(err).Msg("RenderError Error")
 	}
 }
+
+// adminFromRequest returns the logged in user if they are a admin,
+// otherwise redirecting to the login page or rendering a error.
+func adminFromRequest(w http.ResponseWriter, r *http.Request) (types.User, bool) {
+	user, loggedIn := DB.CheckForLoggedInUser(r.Context(), r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return user, false
+	}
+	if !user.Admin {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
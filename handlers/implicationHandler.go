@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// ImplicationsTemplate contains data to be used in the template.
+type ImplicationsTemplate struct {
+	Implications []types.TagImplication
+	templates.T
+}
+
+// ImplicationsPageHandler shows the admin page for tag implications.
+func ImplicationsPageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+	implications, err := DB.TagImplications(ctx)
+	if err != nil {
+		renderError(w, "IMPLICATIONS_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "implications.html", ImplicationsTemplate{
+		Implications: implications,
+		T: templates.T{
+			LoggedIn:     true,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// ImplicationsHandler adds or removes a tag implication.
+func ImplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	antecedent, consequent := r.PostFormValue("antecedent"), r.PostFormValue("consequent")
+	switch r.PostFormValue("action") {
+	case "add":
+		err = DB.AddImplication(ctx, user.Username, antecedent, consequent)
+	case "remove":
+		err = DB.RemoveImplication(ctx, antecedent, consequent)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Implications")
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrInvalidImplication) || errors.Is(err, database.ErrImplicationCycle) {
+			status = http.StatusBadRequest
+		}
+		renderError(w, "IMPLICATION_ERR", err, status)
+		return
+	}
+	http.Redirect(w, r, "/admin/implications", http.StatusFound)
+}
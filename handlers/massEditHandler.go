@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// MassEditTemplate contains data to be used in the template.
+type MassEditTemplate struct {
+	Edits      []types.MassTagEdit
+	Page       int
+	TotalPages int
+	// Query, Add, Remove and Renames are the form's values, kept after a preview.
+	Query   string
+	Add     string
+	Remove  string
+	Renames string
+	// Previewed is true when the form was submitted to preview the edit.
+	Previewed    bool
+	PreviewCount int
+	PreviewPosts []types.Post
+	templates.T
+}
+
+// massTagEditFromRequest reads a mass tag edit from the form.
+func massTagEditFromRequest(r *http.Request) (types.MassTagEdit, error) {
+	renames, err := database.ParseTagRenames(r.PostFormValue("renames"))
+	if err != nil {
+		return types.MassTagEdit{}, err
+	}
+	return types.MassTagEdit{
+		Query:   r.PostFormValue("query"),
+		Add:     utils.SplitTagsString(r.PostFormValue("add")),
+		Remove:  utils.SplitTagsString(r.PostFormValue("remove")),
+		Renames: renames,
+	}, nil
+}
+
+// renderMassEdit renders the mass tag edit page with a page of the log.
+func renderMassEdit(w http.ResponseWriter, r *http.Request, user types.User, tmpl MassEditTemplate) {
+	ctx := r.Context()
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	edits, numPages, err := DB.MassTagEdits(ctx, page)
+	if err != nil {
+		renderError(w, "MASS_EDIT_ERR", err, http.StatusInternalServerError)
+		return
+	}
+	tmpl.Edits = edits
+	tmpl.Page = page
+	tmpl.TotalPages = numPages
+	tmpl.T = templates.T{
+		LoggedIn:     true,
+		LoggedInUser: user,
+		Translator:   i18n.GetTranslator(r),
+	}
+
+	err = templates.RenderTemplate(w, "massEdit.html", tmpl)
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// MassEditPageHandler shows the admin page for mass tag edits.
+func MassEditPageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+	renderMassEdit(w, r, user, MassEditTemplate{})
+}
+
+// MassEditHandler previews, applies or undoes a mass tag edit.
+func MassEditHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := adminFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.PostFormValue("action") {
+	case "preview":
+		var e types.MassTagEdit
+		if e, err = massTagEditFromRequest(r); err != nil {
+			break
+		}
+		var count int
+		var posts []types.Post
+		if count, posts, err = DB.PreviewMassTagEdit(ctx, e); err != nil {
+			break
+		}
+		renderMassEdit(w, r, user, MassEditTemplate{
+			Query:        r.PostFormValue("query"),
+			Add:          r.PostFormValue("add"),
+			Remove:       r.PostFormValue("remove"),
+			Renames:      r.PostFormValue("renames"),
+			Previewed:    true,
+			PreviewCount: count,
+			PreviewPosts: posts,
+		})
+		return
+	case "apply":
+		var e types.MassTagEdit
+		if e, err = massTagEditFromRequest(r); err != nil {
+			break
+		}
+		_, err = DB.MassTagEdit(ctx, user.Username, e)
+	case "undo":
+		var id int64
+		if id, err = strconv.ParseInt(r.PostFormValue("id"), 10, 64); err != nil {
+			err = database.ErrMassEditNotExist
+			break
+		}
+		err = DB.UndoMassTagEdit(ctx, user.Username, id)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Mass Edit")
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrInvalidMassEdit) || errors.Is(err, database.ErrMassEditNotExist) ||
+			errors.Is(err, database.ErrMassEditUndone) {
+			status = http.StatusBadRequest
+		}
+		renderError(w, "MASS_EDIT_ERR", err, status)
+		return
+	}
+	http.Redirect(w, r, "/admin/massEdit", http.StatusFound)
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// canEditNote checks if a user can edit or delete a note, which is the
+// note's creator, the post's poster or a admin.
+func canEditNote(user types.User, loggedIn bool, n types.Note, post types.Post) bool {
+	return loggedIn && (user.Admin || n.Username == user.Username || post.Poster == user.Username)
+}
+
+// notesURL returns the URL of the notes on a post's page.
+func notesURL(postID int64) string {
+	return "/view/" + strconv.FormatInt(postID, 10) + "#notes"
+}
+
+// noteRectFromRequest reads the region of a note from the x, y, width
+// and height form values.
+func noteRectFromRequest(r *http.Request) (rect types.NoteRect, err error) {
+	for _, f := range []struct {
+		name  string
+		value *int64
+	}{{"x", &rect.X}, {"y", &rect.Y}, {"width", &rect.Width}, {"height", &rect.Height}} {
+		*f.value, err = strconv.ParseInt(r.FormValue(f.name), 10, 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// NoteHandler is the endpoint used to add a note to a post.
+func NoteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	rect, err := noteRectFromRequest(r)
+	if err != nil {
+		renderError(w, "NOTE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	if _, err = DB.AddNote(ctx, user.Username, postID, rect, r.PostFormValue("body")); err != nil {
+		log.Error().Err(err).Msg("Add Note")
+		renderError(w, "NOTE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, notesURL(postID), http.StatusFound)
+}
+
+// noteFromRequest fetches the note in the URL and checks the logged
+// in user can change it, rendering a error if not.
+func noteFromRequest(w http.ResponseWriter, r *http.Request) (types.User, types.Note, bool) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return user, types.Note{}, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["noteID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_NOTE_ID", err, http.StatusBadRequest)
+		return user, types.Note{}, false
+	}
+	n, err := DB.Note(ctx, id)
+	if err != nil {
+		renderError(w, "NOTE_NOT_FOUND", err, http.StatusNotFound)
+		return user, types.Note{}, false
+	}
+	post, err := DB.Post(ctx, n.PostID)
+	if err != nil || !canEditNote(user, loggedIn, n, post) {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return user, types.Note{}, false
+	}
+	return user, n, true
+}
+
+// EditNoteHandler is the endpoint used to edit a note.
+func EditNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, n, ok := noteFromRequest(w, r)
+	if !ok {
+		return
+	}
+	rect, err := noteRectFromRequest(r)
+	if err != nil {
+		renderError(w, "NOTE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	if err := DB.EditNote(r.Context(), user.Username, n.ID, rect, r.PostFormValue("body")); err != nil {
+		log.Error().Err(err).Msg("Edit Note")
+		renderError(w, "NOTE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, notesURL(n.PostID), http.StatusFound)
+}
+
+// DeleteNoteHandler is the endpoint used to delete a note.
+func DeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, n, ok := noteFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := DB.DeleteNote(r.Context(), user.Username, n.ID); err != nil {
+		log.Error().Err(err).Msg("Delete Note")
+		renderError(w, "NOTE_ERR", err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, notesURL(n.PostID), http.StatusFound)
+}
+
+// NoteHistoryTemplate contains data to be used in the template.
+type NoteHistoryTemplate struct {
+	Post     types.Post
+	Versions []types.NoteVersion
+	templates.T
+}
+
+// NoteHistoryHandler shows every version of the notes on a post.
+func NoteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	postID, err := strconv.ParseInt(mux.Vars(r)["postID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	post, err := DB.Post(ctx, postID)
+	if err != nil {
+		renderError(w, "POST_NOT_FOUND", err, http.StatusNotFound)
+		return
+	}
+	if !loggedIn && !DB.RatingVisible(user, post.Rating) {
+		renderError(w, "LOGIN_REQUIRED", errors.New("Login required to view this post"), http.StatusForbidden)
+		return
+	}
+	versions, err := DB.PostNoteVersions(ctx, postID)
+	if err != nil {
+		renderError(w, "NOTES_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "noteHistory.html", NoteHistoryTemplate{
+		Post:     post,
+		Versions: versions,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
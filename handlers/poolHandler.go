@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// poolPostsPerPage is how many posts are shown on each page of a pool.
+const poolPostsPerPage = 60
+
+// canEditPool checks if a user can change a pool, which is only its owner or a admin.
+func canEditPool(user types.User, loggedIn bool, p types.Pool) bool {
+	return loggedIn && (user.Admin || p.Owner == user.Username)
+}
+
+// poolURL returns the URL of a pool's page.
+func poolURL(id int64) string {
+	return "/pool/" + strconv.FormatInt(id, 10)
+}
+
+// parsePostIDs parses a whitespace separated list of post IDs.
+func parsePostIDs(s string) ([]int64, error) {
+	fields := strings.Fields(s)
+	ids := make([]int64, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.ParseInt(strings.TrimPrefix(f, "#"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// PoolsTemplate contains data to be used in the template.
+type PoolsTemplate struct {
+	Pools      []types.Pool
+	Page       int
+	TotalPages int
+	templates.T
+}
+
+// PoolsHandler lists the pools, most recently changed first.
+func PoolsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	pools, numPages, err := DB.Pools(ctx, page)
+	if err != nil {
+		renderError(w, "POOLS_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "pools.html", PoolsTemplate{
+		Pools:      pools,
+		Page:       page,
+		TotalPages: numPages,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// CreatePoolHandler is the endpoint used to create a pool.
+func CreatePoolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	id, err := DB.CreatePool(ctx, user.Username, r.PostFormValue("name"), r.PostFormValue("description"))
+	if err != nil {
+		log.Error().Err(err).Msg("Create Pool")
+		renderError(w, "POOL_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, poolURL(id), http.StatusFound)
+}
+
+// PoolTemplate contains data to be used in the template.
+type PoolTemplate struct {
+	Pool types.Pool
+	// Posts are the posts on the current page of the pool.
+	Posts        []types.Post
+	Page         int
+	TotalPages   int
+	IsAbleToEdit bool
+	// PostIDs are the IDs of all the posts in the pool, for editing the order.
+	PostIDs string
+	templates.T
+}
+
+// PoolHandler shows a page of the posts in a pool in order.
+func PoolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["poolID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POOL_ID", err, http.StatusBadRequest)
+		return
+	}
+	pool, err := DB.Pool(ctx, id)
+	if err != nil {
+		renderError(w, "POOL_NOT_FOUND", err, http.StatusNotFound)
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	visible := visiblePosts(r, user, paginatePostIDs(pool.Posts, page, poolPostsPerPage))
+	ids := make([]string, len(pool.Posts))
+	for i, postID := range pool.Posts {
+		ids[i] = strconv.FormatInt(postID, 10)
+	}
+
+	err = templates.RenderTemplate(w, "pool.html", PoolTemplate{
+		Pool:         pool,
+		Posts:        visible,
+		Page:         page,
+		TotalPages:   (len(pool.Posts) + poolPostsPerPage - 1) / poolPostsPerPage,
+		IsAbleToEdit: canEditPool(user, loggedIn, pool),
+		PostIDs:      strings.Join(ids, "\n"),
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// paginatePostIDs returns a page of post IDs.
+func paginatePostIDs(ids []int64, page int, pageSize int) []int64 {
+	start := page * pageSize
+	if start > len(ids) {
+		start = len(ids)
+	}
+	end := start + pageSize
+	if end > len(ids) {
+		end = len(ids)
+	}
+	return ids[start:end]
+}
+
+// poolFromRequest fetches the pool in the URL and checks the logged in
+// user can change it, rendering a error if not.
+func poolFromRequest(w http.ResponseWriter, r *http.Request) (types.Pool, bool) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return types.Pool{}, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["poolID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POOL_ID", err, http.StatusBadRequest)
+		return types.Pool{}, false
+	}
+	pool, err := DB.Pool(ctx, id)
+	if err != nil {
+		renderError(w, "POOL_NOT_FOUND", err, http.StatusNotFound)
+		return types.Pool{}, false
+	}
+	if !canEditPool(user, loggedIn, pool) {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return types.Pool{}, false
+	}
+	return pool, true
+}
+
+// EditPoolHandler is the endpoint used to change a pool's name, description
+// and posts, which are given in order as a list of post IDs.
+func EditPoolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pool, ok := poolFromRequest(w, r)
+	if !ok {
+		return
+	}
+	postIDs, err := parsePostIDs(r.PostFormValue("posts"))
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	if err = DB.EditPool(ctx, pool.ID, r.PostFormValue("name"), r.PostFormValue("description")); err != nil {
+		log.Error().Err(err).Msg("Edit Pool")
+		renderError(w, "POOL_ERR", err, http.StatusBadRequest)
+		return
+	}
+	if err = DB.SetPoolPosts(ctx, pool.ID, postIDs); err != nil {
+		log.Error().Err(err).Msg("Edit Pool")
+		renderError(w, "POOL_ERR", err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, poolURL(pool.ID), http.StatusFound)
+}
+
+// DeletePoolHandler is the endpoint used to delete a pool.
+func DeletePoolHandler(w http.ResponseWriter, r *http.Request) {
+	pool, ok := poolFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := DB.DeletePool(r.Context(), pool.ID); err != nil {
+		log.Error().Err(err).Msg("Delete Pool")
+		renderError(w, "POOL_ERR", err, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/pools", http.StatusFound)
+}
+
+// PoolPostHandler is the endpoint used to add a post to the end of a pool
+// or remove it from the pool.
+func PoolPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pool, ok := poolFromRequest(w, r)
+	if !ok {
+		return
+	}
+	postID, err := strconv.ParseInt(r.PostFormValue("postID"), 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	if r.PostFormValue("action") == "remove" {
+		err = DB.RemovePoolPost(ctx, pool.ID, postID)
+	} else {
+		err = DB.AddPoolPost(ctx, pool.ID, postID)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Pool Post")
+		renderError(w, "POOL_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/view/"+strconv.FormatInt(postID, 10), http.StatusFound)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+// popularLimit is how many posts are shown on the popular page.
+const popularLimit = 60
+
+// PopularTemplate contains data to be used in the template.
+type PopularTemplate struct {
+	// Posts are the most voted for posts in the time window.
+	Posts []types.Post
+	// Days is how many days of votes are counted.
+	Days int
+	templates.T
+}
+
+// PopularHandler shows the posts with the most votes in the last week, or
+// the number of days given by the days parameter.
+func PopularHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 || days > 365 {
+		days = 7
+	}
+	posts, err := DB.PopularPosts(ctx, user, time.Now().AddDate(0, 0, -days), popularLimit)
+	if err != nil {
+		renderError(w, "POPULAR_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "popular.html", PopularTemplate{
+		Posts: posts,
+		Days:  days,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
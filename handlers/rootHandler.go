@@ -32,7 +32,7 @@ func RootHandler(w http.ResponseWriter, r *http.Request) {
 			LoggedInUser: user,
 			Translator:   i18n.GetTranslator(r),
 		},
-		PostPopularity: DB.TopNCommonTags(ctx, 20, []string{"*"}, false),
+		PostPopularity: DB.PopularTags(ctx, user, 20),
 	}
 	err := templates.RenderTemplate(w, "index.html", x)
 	if err != nil {
@@ -34,6 +34,10 @@ type SearchResultsTemplate struct {
 	Tags string
 
 	TagCounts []types.TagCounts
+	// Wiki is the wiki page of the tag when searching a single tag
+	// which has one, and HasWiki is whether there is one.
+	Wiki    types.WikiPage
+	HasWiki bool
 	templates.T
 }
 
@@ -77,15 +81,22 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	wg.Add(2)
 	// Run these both in parallel to make page load faster if they both take long times.
 	go func() {
-		matchingPosts, numPosts, numPages = DB.GetSearchIDs(ctx, tags, page)
+		matchingPosts, numPosts, numPages = DB.GetSearchIDs(ctx, user, tags, page)
 		wg.Done()
 	}()
 	go func() {
-		tagCounts = DB.TopNCommonTags(ctx, 30, tags, false)
+		tagCounts = DB.TopNCommonTags(ctx, user, 30, tags, false)
 		wg.Done()
 	}()
 	wg.Wait()
 
+	var wiki types.WikiPage
+	hasWiki := false
+	if len(tags) == 1 {
+		wiki, err = DB.WikiPage(ctx, DB.AliasedTag(ctx, utils.FilterString(tags[0])))
+		hasWiki = err == nil
+	}
+
 	searchResults := SearchResultsTemplate{
 		Results:    matchingPosts,
 		RealPage:   page,
@@ -96,6 +107,8 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		Prev:       prevPage,
 		Tags:       tagsStr,
 		TagCounts:  tagCounts,
+		Wiki:       wiki,
+		HasWiki:    hasWiki,
 		T: templates.T{
 			LoggedIn:     loggedIn,
 			LoggedInUser: user,
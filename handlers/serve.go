@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// fileETag makes a ETag from a file's size and modification time, which
+// change whenever the file is written so the file never has to be read.
+// The ETag is weak as a file rewritten within the clock's resolution with
+// the same size would keep its ETag, so it can't be used for If-Range.
+func fileETag(size int64, modTime time.Time) string {
+	return `W/"` + strconv.FormatInt(size, 16) + "-" + strconv.FormatInt(modTime.UnixNano(), 16) + `"`
+}
+
+// serveFile serves a file from any storage backend. Range requests are
+// supported for seeking in videos, and conditional requests are answered
+// using a weak ETag made from the file's size and modification time.
+// If the storage doesn't know when the file was modified, modTime is used.
+func serveFile(w http.ResponseWriter, r *http.Request, s types.Storage, name string, mimeType string, modTime time.Time) {
+	f, err := s.Open(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Str("file", name).Msg("Can't open file")
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Error().Err(err).Str("file", name).Msg("Can't stat file")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if !info.ModTime().IsZero() {
+		modTime = info.ModTime()
+	}
+
+	w.Header().Set("ETag", fileETag(info.Size(), modTime))
+	if mimeType != "" {
+		w.Header().Set("Content-Type", mimeType)
+	}
+
+	http.ServeContent(w, r, name, modTime, f)
+}
+
+// setFileCacheControl sets the Cache-Control header for a file. When signed
+// URLs are enabled files are only cached privately until the URL expires.
+func setFileCacheControl(w http.ResponseWriter, cacheControl string) {
+	if DB.Settings.SignedURLs {
+		cacheControl = "private, max-age=" + strconv.FormatInt(DB.SignedURLExpiry(), 10)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+}
@@ -2,10 +2,9 @@ package handlers
 
 import (
 	"fmt"
-
-	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -15,36 +14,39 @@ import (
 func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var err error
 	vars := mux.Vars(r)
-	postID, err := strconv.Atoi(vars["postID"])
+	postID, err := strconv.ParseInt(vars["postID"], 10, 64)
 	if err != nil {
+		http.NotFound(w, r)
 		return
 	}
-	var cacheFile io.ReadCloser
 
 	cacheFilename := fmt.Sprintf("%d.webp", postID)
-	cacheFile, err = DB.ThumbnailsStorage.ReadFile(ctx, cacheFilename)
+	if !DB.VerifySignedURL("thumbnail", cacheFilename, r.URL.Query()) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	post, err := DB.Post(ctx, postID)
 	if err != nil {
-		post, _ := DB.Post(ctx, int64(postID))
-		cacheFilename = DB.CreateThumbnail(ctx, post)
-		cacheFile, err = DB.ThumbnailsStorage.ReadFile(ctx, cacheFilename)
-		if err != nil {
-			log.Error().Err(err).Msg("Open Cache File")
-			return
-		}
+		http.NotFound(w, r)
+		return
 	}
-
-	// Return early if no cache file could be created.
-	if cacheFilename == "" {
+	if !canViewPostFiles(r, post) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
-
-	defer cacheFile.Close()
-
-	w.Header().Set("Cache-Control", "public, immutable, only-if-cached, max-age=2592000")
-	_, err = io.Copy(w, cacheFile)
-	if err != nil {
-		log.Error().Err(err).Msg("Cache File Upload")
+	if f, err := DB.ThumbnailsStorage.Open(cacheFilename); err == nil {
+		f.Close()
+	} else {
+		// Return early if no cache file could be created.
+		if DB.CreateThumbnail(ctx, post) == "" {
+			log.Error().Int64("postID", postID).Msg("Can't create thumbnail")
+			http.NotFound(w, r)
+			return
+		}
 	}
+
+	// Thumbnails can be regenerated so they need to be revalidated using the ETag.
+	setFileCacheControl(w, postFileCacheControl(post, "public, max-age=86400"))
+	serveFile(w, r, DB.ThumbnailsStorage, cacheFilename, "image/webp", time.Time{})
 }
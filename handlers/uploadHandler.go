@@ -11,7 +11,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/NamedKitten/kittehbooru/database"
 	"github.com/NamedKitten/kittehbooru/i18n"
+	"github.com/NamedKitten/kittehbooru/storage"
 	templates "github.com/NamedKitten/kittehbooru/template"
 	"github.com/NamedKitten/kittehbooru/types"
 	"github.com/NamedKitten/kittehbooru/utils"
@@ -39,6 +41,16 @@ var whitelistedTypes = [...]string{
 // Default: 64Mb
 const maxUploadSize = 64 * 1024 * 1024
 
+// parentFromRequest reads the parent post ID from the parent form value,
+// falling back to the one from a parent: tag if the field is empty.
+func parentFromRequest(r *http.Request, tagParent int64) (int64, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(r.PostFormValue("parent")), "#")
+	if value == "" {
+		return tagParent, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 // uploadHandler is the API endpoint for creating posts.
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -49,6 +61,14 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
+	if err := DB.CheckUploadRate(ctx, user); err != nil {
+		if errors.Is(err, database.ErrUploadRateLimited) {
+			renderError(w, "UPLOAD_RATE_LIMITED", err, http.StatusTooManyRequests)
+		} else {
+			renderError(w, "UPLOAD_RATE_LIMITED", err, http.StatusInternalServerError)
+		}
+		return
+	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
 		log.Error().Err(err).Msg("File Too Big")
@@ -102,6 +122,27 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	size := int64(fileBuf.Len())
+	if err := DB.CheckQuota(ctx, user, size); err != nil {
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			renderError(w, "QUOTA_EXCEEDED", err, http.StatusRequestEntityTooLarge)
+		} else {
+			renderError(w, "QUOTA_EXCEEDED", err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	tags, tagRating := database.RatingFromTags(utils.SplitTagsString(r.PostFormValue("tags")))
+	tags, tagParent := database.ParentFromTags(tags)
+	parent, err := parentFromRequest(r, tagParent)
+	if err == nil {
+		err = DB.CheckParent(ctx, 0, parent)
+	}
+	if err != nil {
+		renderError(w, "INVALID_PARENT", err, http.StatusBadRequest)
+		return
+	}
+
 	node, err := snowflake.NewNode(1)
 	if err != nil {
 		panic(err)
@@ -117,9 +158,9 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		renderError(w, "CANT_WRITE_FILE", err, http.StatusInternalServerError)
 		return
 	}
-	defer newFile.Close()
 	if _, err = io.Copy(newFile, fileBuf); err != nil {
 		log.Error().Err(err).Msg("File Write")
+		storage.Abort(newFile)
 		renderError(w, "CANT_WRITE_FILE", err, http.StatusInternalServerError)
 		return
 	}
@@ -131,8 +172,11 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tags := utils.SplitTagsString(r.PostFormValue("tags"))
 	description := r.PostFormValue("description")
+	rating := r.PostFormValue("rating")
+	if !types.ValidRating(rating) {
+		rating = tagRating
+	}
 
 	newTags := make([]string, 0)
 
@@ -154,6 +198,9 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		Poster:        user.Username,
 		CreatedAt:     postID.Time(),
 		MimeType:      mimeType,
+		Size:          size,
+		Rating:        rating,
+		Parent:        parent,
 	}
 	go DB.CreateThumbnail(ctx, p)
 
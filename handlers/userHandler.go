@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/NamedKitten/kittehbooru/database"
 	"github.com/NamedKitten/kittehbooru/i18n"
 	templates "github.com/NamedKitten/kittehbooru/template"
 	"github.com/NamedKitten/kittehbooru/types"
@@ -13,6 +14,16 @@ type UserResultsTemplate struct {
 	AvatarPost   types.Post
 	User         types.User
 	IsAbleToEdit bool
+	// ShowUsage is true when the logged in user can see the user's usage.
+	ShowUsage bool
+	Usage     database.UserUsage
+	Limits    database.UserLimits
+	// Tab is the list of posts shown, either "uploads" or "favorites".
+	Tab string
+	// TabSearch is the search for all the posts in the tab.
+	TabSearch string
+	// TabPosts are the first posts in the tab.
+	TabPosts []int64
 	templates.T
 }
 
@@ -47,6 +58,22 @@ func UserHandler(w http.ResponseWriter, r *http.Request) {
 			Translator:   i18n.GetTranslator(r),
 		},
 	}
+	if templateInfo.IsAbleToEdit || (loggedIn && loggedInUser.Admin) {
+		templateInfo.Usage, err = DB.UserUsage(ctx, user.Username)
+		if err == nil {
+			templateInfo.ShowUsage = true
+			templateInfo.Limits = DB.UserLimits(user)
+		}
+	}
+
+	templateInfo.Tab = r.URL.Query().Get("tab")
+	if templateInfo.Tab == "favorites" {
+		templateInfo.TabSearch = "fav:" + user.Username
+	} else {
+		templateInfo.Tab = "uploads"
+		templateInfo.TabSearch = "user:" + user.Username
+	}
+	templateInfo.TabPosts, _, _ = DB.GetSearchIDs(ctx, loggedInUser, []string{templateInfo.TabSearch}, 0)
 
 	err = templates.RenderTemplate(w, "user.html", templateInfo)
 	if err != nil {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -17,9 +18,44 @@ type ViewResultsTemplate struct {
 	IsAbleToEdit bool
 	Tags         []types.TagCounts
 	Query        string
+	// ImpliedBy maps the post's tags which were added by implications to a tag implying them.
+	ImpliedBy map[string]string
+	// Blacklisted are the post's tags which the logged in user blacklisted.
+	Blacklisted []string
+	// IsFavorite is true when the post is one of the logged in user's favorites.
+	IsFavorite bool
+	// Vote is the logged in user's vote on the post.
+	Vote int
+	// Children are the posts which have this post as their parent.
+	Children []types.Post
+	// Siblings are the other posts with the same parent as this post.
+	Siblings []types.Post
+	// Pools are the pools the post is in with its neighbours in each.
+	Pools []types.PoolNav
+	// Notes are the notes on the post's image.
+	Notes []types.Note
+	// Comments are the current page of comments on the post.
+	Comments []types.Comment
+	// CommentPage is the current page of comments.
+	CommentPage int
+	// CommentPages is how many pages of comments there are.
+	CommentPages int
 	templates.T
 }
 
+// visiblePosts fetches posts in order, leaving out those with ratings the
+// viewer doesn't see.
+func visiblePosts(r *http.Request, viewer types.User, ids []int64) []types.Post {
+	posts, _ := DB.Posts(r.Context(), ids)
+	visible := make([]types.Post, 0, len(posts))
+	for _, p := range posts {
+		if DB.RatingVisible(viewer, p.Rating) {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
+
 func ViewHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -39,6 +75,12 @@ func ViewHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	// Logged in users can view any post they're linked to, but visitors
+	// only see the ratings allowed for them.
+	if !loggedIn && !DB.RatingVisible(user, post.Rating) {
+		renderError(w, "LOGIN_REQUIRED", errors.New("Login required to view this post"), http.StatusForbidden)
+		return
+	}
 
 	poster, _ := DB.User(ctx, post.Poster)
 
@@ -48,8 +90,10 @@ func ViewHandler(w http.ResponseWriter, r *http.Request) {
 		Post:         post,
 		Author:       poster,
 		IsAbleToEdit: (user.Admin || post.Poster == user.Username) && loggedIn,
-		Tags:         DB.TopNCommonTags(ctx, len(post.Tags), post.Tags, true),
+		Tags:         DB.TopNCommonTags(ctx, user, len(post.Tags), post.Tags, true),
 		Query:        query,
+		Blacklisted:  DB.BlacklistedTags(user, post),
+		ImpliedBy:    DB.ImpliedBy(ctx, post.Tags),
 		T: templates.T{
 			LoggedIn:     loggedIn,
 			LoggedInUser: user,
@@ -57,6 +101,34 @@ func ViewHandler(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	if children, err := DB.PostChildren(ctx, post.PostID); err == nil {
+		templateInfo.Children = visiblePosts(r, user, children)
+	}
+	if post.Parent != 0 {
+		if siblings, err := DB.PostChildren(ctx, post.Parent); err == nil {
+			for i, id := range siblings {
+				if id == post.PostID {
+					siblings = append(siblings[:i], siblings[i+1:]...)
+					break
+				}
+			}
+			templateInfo.Siblings = visiblePosts(r, user, siblings)
+		}
+	}
+	templateInfo.Notes, _ = DB.PostNotes(ctx, post.PostID)
+	templateInfo.Pools, _ = DB.PostPools(ctx, post.PostID)
+
+	templateInfo.CommentPage, err = strconv.Atoi(r.URL.Query().Get("cpage"))
+	if err != nil || templateInfo.CommentPage < 0 {
+		templateInfo.CommentPage = 0
+	}
+	templateInfo.Comments, templateInfo.CommentPages, _ = DB.PostComments(ctx, post.PostID, templateInfo.CommentPage)
+
+	if loggedIn {
+		templateInfo.IsFavorite, _ = DB.IsFavorite(ctx, user.Username, post.PostID)
+		templateInfo.Vote, _ = DB.UserVote(ctx, user.Username, post.PostID)
+	}
+
 	err = templates.RenderTemplate(w, "view.html", templateInfo)
 	if err != nil {
 		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// VoteHandler is the endpoint used to vote on a post.
+func VoteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(vars["postID"], 10, 64)
+	if err != nil {
+		renderError(w, "INVALID_POST_ID", err, http.StatusBadRequest)
+		return
+	}
+	value, err := strconv.Atoi(r.PostFormValue("value"))
+	if err != nil {
+		renderError(w, "INVALID_VOTE", err, http.StatusBadRequest)
+		return
+	}
+
+	if err = DB.Vote(ctx, user.Username, postID, value); err != nil {
+		log.Error().Err(err).Msg("Vote")
+		renderError(w, "VOTE_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/view/"+vars["postID"], http.StatusFound)
+}
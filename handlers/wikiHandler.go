@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/i18n"
+	templates "github.com/NamedKitten/kittehbooru/template"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// canEditWikiPage checks if a user can edit a wiki page, which any user
+// can do unless the page has been locked by a admin.
+func canEditWikiPage(user types.User, loggedIn bool, p types.WikiPage) bool {
+	return loggedIn && (!p.Locked || user.Admin)
+}
+
+// WikiPagesTemplate contains data to be used in the template.
+type WikiPagesTemplate struct {
+	Pages      []types.WikiPage
+	Page       int
+	TotalPages int
+	templates.T
+}
+
+// WikiPagesHandler lists the wiki pages, most recently edited first.
+func WikiPagesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	// The form on the page asks for a tag to go to.
+	if tag := utils.FilterString(r.URL.Query().Get("tag")); tag != "" {
+		http.Redirect(w, r, utils.WikiURL(tag), http.StatusFound)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	pages, numPages, err := DB.WikiPages(ctx, page)
+	if err != nil {
+		renderError(w, "WIKI_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "wikiPages.html", WikiPagesTemplate{
+		Pages:      pages,
+		Page:       page,
+		TotalPages: numPages,
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// WikiTemplate contains data to be used in the template.
+type WikiTemplate struct {
+	Tag string
+	// Exists is false when the tag doesn't have a page yet.
+	Exists       bool
+	Page         types.WikiPage
+	IsAbleToEdit bool
+	templates.T
+}
+
+// WikiHandler shows a tag's wiki page, with a form to edit it for users
+// who can. Aliased tags redirect to the page of the tag they are a alias of.
+func WikiHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	tag := mux.Vars(r)["tag"]
+	if wikiTag := DB.WikiTag(ctx, tag); wikiTag != tag {
+		http.Redirect(w, r, utils.WikiURL(wikiTag), http.StatusFound)
+		return
+	}
+	page, err := DB.WikiPage(ctx, tag)
+	if err != nil && !errors.Is(err, database.ErrWikiPageNotExist) {
+		renderError(w, "WIKI_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "wiki.html", WikiTemplate{
+		Tag:          tag,
+		Exists:       err == nil,
+		Page:         page,
+		IsAbleToEdit: canEditWikiPage(user, loggedIn, page),
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
+
+// EditWikiHandler is the endpoint used to create or edit a tag's wiki page.
+func EditWikiHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+	if !loggedIn {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	tag := DB.WikiTag(ctx, mux.Vars(r)["tag"])
+	err := DB.EditWikiPage(ctx, user.Username, tag, r.PostFormValue("body"), user.Admin)
+	if errors.Is(err, database.ErrWikiPageLocked) {
+		renderError(w, "NO_PERMISSIONS", NoPermissionsError, http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("Edit Wiki")
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrInvalidWikiPage) {
+			status = http.StatusBadRequest
+		}
+		renderError(w, "WIKI_ERR", err, status)
+		return
+	}
+	http.Redirect(w, r, utils.WikiURL(tag), http.StatusFound)
+}
+
+// LockWikiHandler is the endpoint used by admins to lock or unlock a wiki page.
+func LockWikiHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := adminFromRequest(w, r); !ok {
+		return
+	}
+	tag := DB.WikiTag(r.Context(), mux.Vars(r)["tag"])
+	if err := DB.SetWikiPageLocked(r.Context(), tag, r.PostFormValue("locked") == "true"); err != nil {
+		log.Error().Err(err).Msg("Lock Wiki")
+		renderError(w, "WIKI_ERR", err, http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, utils.WikiURL(tag), http.StatusFound)
+}
+
+// WikiHistoryTemplate contains data to be used in the template.
+type WikiHistoryTemplate struct {
+	Tag       string
+	Page      types.WikiPage
+	Revisions []types.WikiRevision
+	// IsAbleToEdit allows restoring old revisions.
+	IsAbleToEdit bool
+	templates.T
+}
+
+// WikiHistoryHandler shows every revision of a tag's wiki page.
+func WikiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !DB.SetupCompleted {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+	user, loggedIn := DB.CheckForLoggedInUser(ctx, r)
+
+	tag := DB.WikiTag(ctx, mux.Vars(r)["tag"])
+	page, err := DB.WikiPage(ctx, tag)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrWikiPageNotExist) {
+			status = http.StatusNotFound
+		}
+		renderError(w, "WIKI_ERR", err, status)
+		return
+	}
+	revisions, err := DB.WikiRevisions(ctx, tag)
+	if err != nil {
+		renderError(w, "WIKI_ERR", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = templates.RenderTemplate(w, "wikiHistory.html", WikiHistoryTemplate{
+		Tag:          tag,
+		Page:         page,
+		Revisions:    revisions,
+		IsAbleToEdit: canEditWikiPage(user, loggedIn, page),
+		T: templates.T{
+			LoggedIn:     loggedIn,
+			LoggedInUser: user,
+			Translator:   i18n.GetTranslator(r),
+		},
+	})
+	if err != nil {
+		renderError(w, "TEMPLATE_RENDER_ERROR", err, http.StatusBadRequest)
+	}
+}
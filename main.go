@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"os"
 
+	"github.com/NamedKitten/kittehbooru/commands"
 	"github.com/NamedKitten/kittehbooru/start"
 )
 
@@ -10,5 +12,8 @@ var conf = flag.String("conf", "settings.yaml", "config file")
 
 func main() {
 	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(commands.Run(*conf, flag.Args()))
+	}
 	start.Start(*conf)
 }
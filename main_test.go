@@ -0,0 +1,135 @@
+package main
+
+// Handler tests live here rather than in the handlers package as the
+// templates and translations are loaded relative to the repository root.
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/NamedKitten/kittehbooru/database"
+	"github.com/NamedKitten/kittehbooru/handlers"
+	fileBackend "github.com/NamedKitten/kittehbooru/storage/backends/file"
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/gorilla/mux"
+)
+
+// mockDB sets the database used by the handlers to a mock.
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqldb.Close() })
+	handlers.DB = database.NewDB(sqldb, database.Settings{})
+	return mock
+}
+
+// expectLoggedIn expects the queries checking a session for a user.
+func expectLoggedIn(mock sqlmock.Sqlmock, token string, user types.User) {
+	mock.ExpectQuery(regexp.QuoteMeta(`from sessions where token = $1`)).WithArgs(token).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "expiry"}).AddRow(user.Username, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`from users where username = $1`)).WithArgs(user.Username).
+		WillReturnRows(sqlmock.NewRows([]string{"avatarID", "owner", "admin", "username", "description", "theme", "storageQuota", "postQuota", "uploadsPerHour", "ratings", "blacklist"}).
+			AddRow(0, user.Owner, user.Admin, user.Username, "", "", 0, 0, 0, "", ""))
+}
+
+// expectPost expects a post with a PNG content file to be fetched.
+func expectPost(mock sqlmock.Sqlmock, postID int64, rating string) {
+	filename := strconv.FormatInt(postID, 10)
+	mock.ExpectQuery(regexp.QuoteMeta(`from posts where postID = $1`)).WithArgs(postID).
+		WillReturnRows(sqlmock.NewRows([]string{"filename", "ext", "description", "tags", "poster", "timestamp", "mimetype", "size", "rating", "score", "parent", "favorites"}).
+			AddRow(filename, "png", "", "", "kitten", 0, "image/png", 16, rating, 0, 0, 0))
+}
+
+func TestEditLockedWikiPageWithOtherCase(t *testing.T) {
+	mock := mockDB(t)
+	expectLoggedIn(mock, "wiki-token", types.User{Username: "kitten"})
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM tag_aliases`)).
+		WillReturnRows(sqlmock.NewRows([]string{"antecedent", "consequent"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "locked" FROM wiki_pages WHERE "tag" = $1 FOR UPDATE`)).WithArgs("cat").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectRollback()
+
+	form := url.Values{"body": {"vandalised"}}
+	r := httptest.NewRequest("POST", "/wiki/%20Cat", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "sessionToken", Value: "wiki-token"})
+	r = mux.SetURLVars(r, map[string]string{"tag": " Cat"})
+	w := httptest.NewRecorder()
+	handlers.EditWikiHandler(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("editing a locked page got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContentETagIsWeak(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := ioutil.WriteFile(dir+"5.png", []byte("not really a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mock := mockDB(t)
+	handlers.DB.ContentStorage = fileBackend.New(dir)
+	expectPost(mock, 5, types.RatingSafe)
+
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/content/5.png", nil), map[string]string{"filename": "5.png"})
+	w := httptest.NewRecorder()
+	handlers.ContentHandler(w, r)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("got status %d and ETag %q, want 200 and a weak ETag", w.Code, etag)
+	}
+
+	expectPost(mock, 5, types.RatingSafe)
+	r = mux.SetURLVars(httptest.NewRequest("GET", "/content/5.png", nil), map[string]string{"filename": "5.png"})
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handlers.ContentHandler(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidating got status %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileAuthChecksRating(t *testing.T) {
+	mock := mockDB(t)
+	handlers.DB.Settings.ContentURL = "/content/"
+	handlers.DB.Settings.ThumbnailURL = "/thumbnail/"
+	tests := []struct {
+		uri    string
+		postID int64
+		rating string
+		want   int
+	}{
+		{"/content/5.png", 5, types.RatingSafe, http.StatusNoContent},
+		{"/content/6.png", 6, types.RatingExplicit, http.StatusForbidden},
+		{"/thumbnail/6.webp", 6, types.RatingExplicit, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		expectPost(mock, test.postID, test.rating)
+		r := httptest.NewRequest("GET", "/auth/file", nil)
+		r.Header.Set("X-Original-URI", test.uri)
+		w := httptest.NewRecorder()
+		handlers.FileAuthHandler(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with rating %s got status %d, want %d", test.uri, test.rating, w.Code, test.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
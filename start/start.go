@@ -77,15 +77,58 @@ func Start(configFile string) {
 	handleFunc("/editPost/{postID}", handlers.EditPostHandler).Methods("POST")
 	handleFunc("/editUser/{userID}", handlers.EditUserHandler).Methods("POST")
 	handleFunc("/view/{postID}", handlers.ViewHandler)
+	handleFunc("/favorite/{postID}", handlers.FavoriteHandler).Methods("POST")
+	handleFunc("/vote/{postID}", handlers.VoteHandler).Methods("POST")
+	handleFunc("/popular", handlers.PopularHandler).Methods("GET")
+	handleFunc("/comment/{postID}", handlers.CommentHandler).Methods("POST")
+	handleFunc("/editComment/{commentID}", handlers.EditCommentHandler).Methods("POST")
+	handleFunc("/deleteComment/{commentID}", handlers.DeleteCommentHandler).Methods("POST")
+	handleFunc("/comments", handlers.RecentCommentsHandler).Methods("GET")
+	handleFunc("/note/{postID}", handlers.NoteHandler).Methods("POST")
+	handleFunc("/editNote/{noteID}", handlers.EditNoteHandler).Methods("POST")
+	handleFunc("/deleteNote/{noteID}", handlers.DeleteNoteHandler).Methods("POST")
+	handleFunc("/noteHistory/{postID}", handlers.NoteHistoryHandler).Methods("GET")
+	handleFunc("/pools", handlers.PoolsHandler).Methods("GET")
+	handleFunc("/createPool", handlers.CreatePoolHandler).Methods("POST")
+	handleFunc("/pool/{poolID}", handlers.PoolHandler).Methods("GET")
+	handleFunc("/editPool/{poolID}", handlers.EditPoolHandler).Methods("POST")
+	handleFunc("/deletePool/{poolID}", handlers.DeletePoolHandler).Methods("POST")
+	handleFunc("/poolPost/{poolID}", handlers.PoolPostHandler).Methods("POST")
+	handleFunc("/wiki", handlers.WikiPagesHandler).Methods("GET")
+	handleFunc("/wiki/{tag}", handlers.WikiHandler).Methods("GET")
+	handleFunc("/wiki/{tag}", handlers.EditWikiHandler).Methods("POST")
+	handleFunc("/wiki/{tag}/lock", handlers.LockWikiHandler).Methods("POST")
+	handleFunc("/wiki/{tag}/history", handlers.WikiHistoryHandler).Methods("GET")
 	handleFunc("/user/{userID}", handlers.UserHandler)
+	handleFunc("/api/v1/posts/{postID}", handlers.APIPostHandler).Methods("GET")
+	handleFunc("/api/v1/search", handlers.APISearchHandler).Methods("GET")
+	handleFunc("/api/v1/posts/{postID}/favorite", handlers.APIFavoriteHandler).Methods("POST", "DELETE")
+	handleFunc("/api/v1/posts/{postID}/vote", handlers.APIVoteHandler).Methods("POST")
+	handleFunc("/api/v1/posts/{postID}/comments", handlers.APIPostCommentsHandler).Methods("GET", "POST")
+	handleFunc("/api/v1/comments/{commentID}", handlers.APICommentHandler).Methods("GET", "PATCH", "DELETE")
+	handleFunc("/api/v1/posts/{postID}/notes", handlers.APIPostNotesHandler).Methods("GET", "POST")
+	handleFunc("/api/v1/posts/{postID}/notes/history", handlers.APIPostNoteHistoryHandler).Methods("GET")
+	handleFunc("/api/v1/notes/{noteID}", handlers.APINoteHandler).Methods("GET", "PATCH", "DELETE")
+	handleFunc("/api/v1/pools", handlers.APIPoolsHandler).Methods("GET", "POST")
+	handleFunc("/api/v1/pools/{poolID}", handlers.APIPoolHandler).Methods("GET", "PATCH", "DELETE")
+	handleFunc("/api/v1/pools/{poolID}/posts/{postID}", handlers.APIPoolPostHandler).Methods("POST", "DELETE")
+	handleFunc("/api/v1/wiki/{tag}", handlers.APIWikiHandler).Methods("GET")
+	handleFunc("/api/v1/tags/autocomplete", handlers.APITagAutocompleteHandler).Methods("GET")
+	handleFunc("/admin/export", handlers.ExportHandler).Methods("GET")
+	handleFunc("/admin/fsck", handlers.FsckPageHandler).Methods("GET")
+	handleFunc("/admin/fsck", handlers.FsckHandler).Methods("POST")
+	handleFunc("/admin/aliases", handlers.AliasesPageHandler).Methods("GET")
+	handleFunc("/admin/aliases", handlers.AliasesHandler).Methods("POST")
+	handleFunc("/admin/implications", handlers.ImplicationsPageHandler).Methods("GET")
+	handleFunc("/admin/implications", handlers.ImplicationsHandler).Methods("POST")
+	handleFunc("/admin/categories", handlers.CategoriesPageHandler).Methods("GET")
+	handleFunc("/admin/categories", handlers.CategoriesHandler).Methods("POST")
+	handleFunc("/admin/massEdit", handlers.MassEditPageHandler).Methods("GET")
+	handleFunc("/admin/massEdit", handlers.MassEditHandler).Methods("POST")
 	addPprof(r)
 
-	r.PathPrefix("/content/").Handler(
-		taskMiddleware("/content/",
-			cacheMiddleware(
-				http.StripPrefix("/content/",
-					http.FileServer(DB.ContentStorage),
-				))))
+	handleFunc("/content/{filename}", handlers.ContentHandler)
+	handleFunc("/auth/file", handlers.FileAuthHandler).Methods("GET")
 	r.PathPrefix("/css/").Handler(cacheMiddleware(http.StripPrefix("/css/", http.FileServer(http.Dir("frontend/css")))))
 	r.PathPrefix("/js/").Handler(cacheMiddleware(http.StripPrefix("/js/", http.FileServer(http.Dir("frontend/js")))))
 	handleFunc("/thumbnail/{postID}.webp", handlers.ThumbnailHandler)
@@ -0,0 +1,363 @@
+package encryptedBackend
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"runtime/trace"
+
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+// Encrypted files start with a header of the magic bytes followed by a random
+// nonce prefix. The content follows in chunks of chunkSize bytes, each sealed
+// with AES-GCM using the nonce prefix and the chunk index as the nonce, so any
+// chunk can be decrypted on its own when seeking. The last chunk is sealed
+// with different additional data so truncated files are detected.
+const (
+	magic      = "KBENC\x00\x00\x01"
+	prefixSize = 8
+	headerSize = len(magic) + prefixSize
+	chunkSize  = 64 * 1024
+	overhead   = 16
+	sealedSize = chunkSize + overhead
+)
+
+var (
+	ErrNotEncrypted = errors.New("File is not encrypted")
+	ErrCorrupted    = errors.New("Encrypted file is corrupted")
+)
+
+// EncryptedBackend encrypts files before storing them in another storage.
+type EncryptedBackend struct {
+	inner types.Storage
+	aead  cipher.AEAD
+}
+
+// nonce returns the nonce used for a chunk.
+func nonce(prefix []byte, index uint32) []byte {
+	n := make([]byte, prefixSize+4)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], index)
+	return n
+}
+
+// additionalData marks if a chunk is the last chunk of a file.
+func additionalData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptedWriter encrypts everything written to it in chunks.
+type encryptedWriter struct {
+	w      types.WriteableFile
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	index  uint32
+	err    error
+}
+
+func (e *encryptedWriter) seal(last bool) {
+	if e.err != nil {
+		return
+	}
+	sealed := e.aead.Seal(nil, nonce(e.prefix, e.index), e.buf, additionalData(last))
+	_, e.err = e.w.Write(sealed)
+	e.index++
+	e.buf = e.buf[:0]
+}
+
+func (e *encryptedWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && e.err == nil {
+		// A full chunk is only sealed once we know it isn't the last one.
+		if len(e.buf) == chunkSize {
+			e.seal(false)
+		}
+		c := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, e.err
+}
+
+// Abort discards the file if the underlying storage supports it.
+func (e *encryptedWriter) Abort() error {
+	if a, ok := e.w.(types.AbortableFile); ok {
+		return a.Abort()
+	}
+	return e.w.Close()
+}
+
+func (e *encryptedWriter) Close() error {
+	e.seal(true)
+	if err := e.w.Close(); e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
+
+// decryptReader decrypts a file read from start to end.
+type decryptReader struct {
+	r      *bufio.Reader
+	c      io.Closer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	plain  []byte
+	done   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	sealed := make([]byte, sealedSize)
+	n, err := io.ReadFull(d.r, sealed)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, perr := d.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	plain, err := d.aead.Open(nil, nonce(d.prefix, d.index), sealed[:n], additionalData(last))
+	if err != nil {
+		return ErrCorrupted
+	}
+	d.index++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.c.Close()
+}
+
+// decryptFile decrypts a file with support for seeking.
+type decryptFile struct {
+	f      http.File
+	aead   cipher.AEAD
+	prefix []byte
+	size   int64
+	offset int64
+	// chunk is the index of the chunk currently decrypted into plain.
+	chunk int64
+	plain []byte
+}
+
+// plaintextSize calculates the size of the content of an encrypted file.
+func plaintextSize(size int64) (int64, error) {
+	body := size - int64(headerSize)
+	if body < overhead {
+		return 0, ErrCorrupted
+	}
+	chunks := (body + sealedSize - 1) / sealedSize
+	if body-(chunks-1)*sealedSize < overhead {
+		return 0, ErrCorrupted
+	}
+	return body - chunks*overhead, nil
+}
+
+func (d *decryptFile) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	index := d.offset / chunkSize
+	if index != d.chunk {
+		if err := d.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.offset%chunkSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *decryptFile) loadChunk(index int64) error {
+	if _, err := d.f.Seek(int64(headerSize)+index*sealedSize, io.SeekStart); err != nil {
+		return err
+	}
+	lastIndex := d.size / chunkSize
+	if d.size%chunkSize == 0 && d.size != 0 {
+		lastIndex--
+	}
+	length := int64(sealedSize)
+	if index == lastIndex {
+		length = d.size - index*chunkSize + overhead
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.f, sealed); err != nil {
+		return err
+	}
+	plain, err := d.aead.Open(nil, nonce(d.prefix, uint32(index)), sealed, additionalData(index == lastIndex))
+	if err != nil {
+		return ErrCorrupted
+	}
+	d.chunk = index
+	d.plain = plain
+	return nil
+}
+
+func (d *decryptFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *decryptFile) Close() error {
+	return d.f.Close()
+}
+
+func (d *decryptFile) Readdir(count int) ([]os.FileInfo, error) {
+	return d.f.Readdir(count)
+}
+
+func (d *decryptFile) Stat() (os.FileInfo, error) {
+	info, err := d.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{info, d.size}, nil
+}
+
+// fileInfo reports the size of the decrypted content.
+type fileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.size
+}
+
+// readHeader reads the header of an encrypted file and returns the nonce prefix.
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrNotEncrypted
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	return header[len(magic):], nil
+}
+
+func (eb EncryptedBackend) Open(s string) (http.File, error) {
+	f, err := eb.inner.Open(s)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return f, nil
+	}
+	prefix, err := readHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size, err := plaintextSize(info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decryptFile{f: f, aead: eb.aead, prefix: prefix, size: size, chunk: -1}, nil
+}
+
+func (eb EncryptedBackend) Delete(s string) error {
+	return eb.inner.Delete(s)
+}
+
+func (eb EncryptedBackend) ReadFile(ctx context.Context, s string) (types.ReadableFile, error) {
+	defer trace.StartRegion(ctx, "EncryptedStorage/ReadFile").End()
+	f, err := eb.inner.ReadFile(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(f, sealedSize)
+	prefix, err := readHeader(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decryptReader{r: r, c: f, aead: eb.aead, prefix: prefix}, nil
+}
+
+func (eb EncryptedBackend) WriteFile(ctx context.Context, s string) (types.WriteableFile, error) {
+	defer trace.StartRegion(ctx, "EncryptedStorage/WriteFile").End()
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	f, err := eb.inner.WriteFile(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	w := &encryptedWriter{w: f, aead: eb.aead, prefix: prefix, buf: make([]byte, 0, chunkSize)}
+	if _, err := f.Write(append([]byte(magic), prefix...)); err != nil {
+		// Closing would replace any existing file with the partial header.
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (eb EncryptedBackend) List(ctx context.Context) ([]string, error) {
+	return eb.inner.List(ctx)
+}
+
+// New creates a storage which encrypts all files stored in inner using a 32 byte key.
+func New(inner types.Storage, key []byte) (EncryptedBackend, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return EncryptedBackend{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return EncryptedBackend{}, err
+	}
+	return EncryptedBackend{inner, aead}, nil
+}
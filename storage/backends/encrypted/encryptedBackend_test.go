@@ -0,0 +1,270 @@
+package encryptedBackend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	fileBackend "github.com/NamedKitten/kittehbooru/storage/backends/file"
+	"github.com/NamedKitten/kittehbooru/storage/storagetest"
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+// newTestBackend creates a encrypted storage in a temporary directory,
+// returning it and the directory.
+func newTestBackend(t *testing.T) (EncryptedBackend, string) {
+	dir := t.TempDir() + "/"
+	eb, err := New(fileBackend.New(dir), bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eb, dir
+}
+
+// testData returns size bytes of data which differ at every offset within a chunk.
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*31 + i/chunkSize)
+	}
+	return data
+}
+
+func writeFile(t *testing.T, eb EncryptedBackend, name string, data []byte) {
+	f, err := eb.WriteFile(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	eb, _ := newTestBackend(t)
+	for _, err := range storagetest.Check(context.Background(), eb) {
+		t.Error(err)
+	}
+}
+
+func TestPlaintextSize(t *testing.T) {
+	tests := []struct {
+		size    int64
+		want    int64
+		wantErr bool
+	}{
+		{int64(headerSize) + overhead, 0, false},
+		{int64(headerSize) + overhead + 1, 1, false},
+		{int64(headerSize) + sealedSize, chunkSize, false},
+		{int64(headerSize) + sealedSize + overhead + 1, chunkSize + 1, false},
+		{int64(headerSize) + 2*sealedSize, 2 * chunkSize, false},
+		{int64(headerSize), 0, true},
+		{int64(headerSize) + overhead - 1, 0, true},
+		// A last chunk too small to hold a tag can't be valid.
+		{int64(headerSize) + sealedSize + overhead - 1, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := plaintextSize(tt.size)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("plaintextSize(%d) = %d, %v, expected %d, error %v", tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestChunkBoundaries(t *testing.T) {
+	eb, _ := newTestBackend(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 2*chunkSize + 5} {
+		data := testData(size)
+		writeFile(t, eb, "file", data)
+
+		r, err := eb.ReadFile(context.Background(), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("ReadFile of %d bytes returned %d bytes, error %v", size, len(got), err)
+		}
+
+		f, err := eb.Open("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Open of %d bytes returned %d bytes, error %v", size, len(got), err)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	eb, _ := newTestBackend(t)
+	data := testData(3*chunkSize + 100)
+	writeFile(t, eb, "file", data)
+
+	f, err := eb.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{chunkSize - 5, io.SeekStart, chunkSize - 5},
+		{0, io.SeekStart, 0},
+		{2 * chunkSize, io.SeekStart, 2 * chunkSize},
+		// Seeking backwards into a earlier chunk.
+		{-chunkSize - 1, io.SeekCurrent, chunkSize - 1},
+		{-50, io.SeekEnd, int64(len(data)) - 50},
+		{3 * chunkSize, io.SeekStart, 3 * chunkSize},
+	}
+	for _, tt := range tests {
+		pos, err := f.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.want {
+			t.Fatalf("Seek(%d, %d) = %d, %v, expected %d", tt.offset, tt.whence, pos, err, tt.want)
+		}
+		// Reads can cross into the next chunk.
+		buf := make([]byte, 20)
+		n, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Read at %d: %v", pos, err)
+		}
+		if !bytes.Equal(buf[:n], data[pos:pos+int64(n)]) {
+			t.Errorf("Read at %d returned the wrong data", pos)
+		}
+		// Reset to where the read started so SeekCurrent is relative to it.
+		f.Seek(pos, io.SeekStart)
+	}
+
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position didn't return a error")
+	}
+	f.Seek(0, io.SeekEnd)
+	if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end returned %d, %v, expected io.EOF", n, err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	eb, dir := newTestBackend(t)
+	writeFile(t, eb, "file", testData(2*chunkSize+5))
+	// Cutting off the last chunk leaves a file of only whole chunks, which
+	// must still be detected as the new last chunk wasn't sealed as the last.
+	if err := os.Truncate(dir+"file", int64(headerSize)+2*sealedSize); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := eb.ReadFile(context.Background(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrCorrupted {
+		t.Errorf("ReadFile of a truncated file returned %v, expected ErrCorrupted", err)
+	}
+	r.Close()
+
+	f, err := eb.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Seek(chunkSize, io.SeekStart)
+	if _, err := f.Read(make([]byte, 1)); err != ErrCorrupted {
+		t.Errorf("Read of the last chunk of a truncated file returned %v, expected ErrCorrupted", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	eb, dir := newTestBackend(t)
+	writeFile(t, eb, "file", testData(chunkSize+10))
+	raw, err := ioutil.ReadFile(dir + "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[headerSize+10] ^= 1
+	if err := ioutil.WriteFile(dir+"file", raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := eb.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The second chunk is still readable on its own.
+	f.Seek(chunkSize, io.SeekStart)
+	if _, err := f.Read(make([]byte, 10)); err != nil {
+		t.Errorf("Read of a untouched chunk returned %v", err)
+	}
+	f.Seek(0, io.SeekStart)
+	if _, err := f.Read(make([]byte, 1)); err != ErrCorrupted {
+		t.Errorf("Read of a tampered chunk returned %v, expected ErrCorrupted", err)
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	eb, dir := newTestBackend(t)
+	if err := ioutil.WriteFile(dir+"plain", []byte("not encrypted at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eb.Open("plain"); err != ErrNotEncrypted {
+		t.Errorf("Open of a plain file returned %v, expected ErrNotEncrypted", err)
+	}
+}
+
+// failingStorage is a file storage where every write fails.
+type failingStorage struct {
+	fileBackend.FileBackend
+}
+
+type failingFile struct {
+	types.WriteableFile
+}
+
+func (failingFile) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func (f failingFile) Abort() error {
+	return f.WriteableFile.(types.AbortableFile).Abort()
+}
+
+func (fs failingStorage) WriteFile(ctx context.Context, s string) (types.WriteableFile, error) {
+	f, err := fs.FileBackend.WriteFile(ctx, s)
+	return failingFile{f}, err
+}
+
+func TestHeaderWriteFails(t *testing.T) {
+	ctx := context.Background()
+	inner := fileBackend.New(t.TempDir() + "/")
+	eb, err := New(inner, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, eb, "cat", []byte("meow"))
+
+	failing, _ := New(failingStorage{inner}, bytes.Repeat([]byte{7}, 32))
+	if _, err := failing.WriteFile(ctx, "cat"); err == nil {
+		t.Fatal("WriteFile succeeded without writing the header")
+	}
+	f, err := eb.ReadFile(ctx, "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, err := ioutil.ReadAll(f); err != nil || string(data) != "meow" {
+		t.Errorf("after a failed write the file has %q, %v, want the old contents", data, err)
+	}
+}
@@ -2,35 +2,250 @@ package fileBackend
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime/trace"
+	"strings"
 
 	"github.com/NamedKitten/kittehbooru/types"
 )
 
+// Layout is how files are laid out in directories.
+type Layout string
+
+const (
+	// LayoutFlat stores all files in a single directory.
+	LayoutFlat Layout = ""
+	// LayoutHash stores files in two levels of directories named after
+	// the MD5 hash of the filename, such as ab/cd/<name>.
+	LayoutHash Layout = "hash"
+	// LayoutPrefix stores files in two levels of directories named after
+	// the end of the filename before the extension, such as 78/56/<id>5678.png.
+	// Post IDs start with the time they were made so only their end varies.
+	LayoutPrefix Layout = "prefix"
+)
+
+var ErrInvalidFilename = errors.New("Invalid filename")
+
 type FileBackend struct {
-	path string
+	path   string
+	layout Layout
+}
+
+// filePath returns the path on disk of a file, rejecting any filenames
+// which could escape the storage directory.
+func (fb FileBackend) filePath(s string) (string, error) {
+	if s == "" || s == "." || s == ".." || strings.HasPrefix(s, tmpPrefix) || strings.ContainsAny(s, "/\\\x00") {
+		return "", ErrInvalidFilename
+	}
+	return fb.path + fb.dir(s) + s, nil
+}
+
+// dir returns the directory a file is stored in relative to the storage path.
+func (fb FileBackend) dir(s string) string {
+	switch fb.layout {
+	case LayoutHash:
+		sum := md5.Sum([]byte(s))
+		h := hex.EncodeToString(sum[:])
+		return h[0:2] + "/" + h[2:4] + "/"
+	case LayoutPrefix:
+		p := s
+		if i := strings.Index(p, "."); i > 0 {
+			p = p[:i]
+		}
+		for len(p) < 4 {
+			p = "_" + p
+		}
+		p = p[len(p)-4:]
+		return p[2:4] + "/" + p[0:2] + "/"
+	}
+	return ""
 }
 
 func (fb FileBackend) Open(s string) (http.File, error) {
-	return os.OpenFile(fb.path+s, os.O_RDONLY, 0666)
+	// http.FileServer asks for paths starting with a slash.
+	p, err := fb.filePath(strings.TrimPrefix(s, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_RDONLY, 0666)
 }
 
 func (fb FileBackend) Delete(s string) error {
-	return os.Remove(fb.path + s)
+	p, err := fb.filePath(s)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
 }
 
 func (fb FileBackend) ReadFile(ctx context.Context, s string) (types.ReadableFile, error) {
 	defer trace.StartRegion(ctx, "FileStorage/ReadFile").End()
-	return os.OpenFile(fb.path+s, os.O_RDONLY, 0666)
+	p, err := fb.filePath(s)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_RDONLY, 0666)
 }
 
 func (fb FileBackend) WriteFile(ctx context.Context, s string) (types.WriteableFile, error) {
 	defer trace.StartRegion(ctx, "FileStorage/WriteFile").End()
-	return os.OpenFile(fb.path+s, os.O_WRONLY|os.O_CREATE, 0666)
+	p, err := fb.filePath(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), tmpPrefix+s+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &atomicFile{f: f, path: p}, nil
+}
+
+// tmpPrefix is the start of the name of files which are still being written.
+const tmpPrefix = ".tmp-"
+
+// atomicFile is written to a temporary file which replaces the real file
+// when closed, so a file is never seen half written.
+type atomicFile struct {
+	f      *os.File
+	path   string
+	err    error
+	closed bool
+}
+
+func (af *atomicFile) Write(p []byte) (int, error) {
+	if af.err != nil {
+		return 0, af.err
+	}
+	n, err := af.f.Write(p)
+	if err != nil {
+		af.err = err
+	}
+	return n, err
+}
+
+// Close syncs the temporary file to disk, renames it to the real file and
+// syncs the directory so the rename is durable. If any write failed the
+// temporary file is removed and the error returned.
+func (af *atomicFile) Close() error {
+	if af.closed {
+		return os.ErrClosed
+	}
+	af.closed = true
+	err := af.err
+	if err == nil {
+		err = af.f.Sync()
+	}
+	if cerr := af.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(af.f.Name(), af.path)
+	}
+	if err != nil {
+		os.Remove(af.f.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(af.path))
+}
+
+// syncDir syncs a directory to disk, so a file renamed into it stays
+// renamed if the system crashes.
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Abort removes the temporary file, leaving any existing file in place.
+func (af *atomicFile) Abort() error {
+	if af.closed {
+		return os.ErrClosed
+	}
+	af.closed = true
+	af.f.Close()
+	return os.Remove(af.f.Name())
+}
+
+// walk calls fn with the name and path of every file in the storage.
+func (fb FileBackend) walk(fn func(name, p string) error) error {
+	return filepath.Walk(fb.path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), tmpPrefix) {
+			return nil
+		}
+		return fn(info.Name(), p)
+	})
+}
+
+func (fb FileBackend) List(ctx context.Context) ([]string, error) {
+	defer trace.StartRegion(ctx, "FileStorage/List").End()
+	names := make([]string, 0)
+	err := fb.walk(func(name, p string) error {
+		names = append(names, name)
+		return nil
+	})
+	return names, err
+}
+
+// Relayout moves every file which isn't where the current layout expects it
+// to be, returning how many files were moved.
+func (fb FileBackend) Relayout(ctx context.Context) (int, error) {
+	defer trace.StartRegion(ctx, "FileStorage/Relayout").End()
+	moves := make(map[string]string)
+	err := fb.walk(func(name, p string) error {
+		newPath, err := fb.filePath(name)
+		if err != nil {
+			return err
+		}
+		if filepath.Clean(p) != filepath.Clean(newPath) {
+			moves[p] = newPath
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for oldPath, newPath := range moves {
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
 }
 
 func New(s string) FileBackend {
-	return FileBackend{s}
+	return FileBackend{path: s}
+}
+
+// NewWithLayout creates a file storage which lays files out in directories.
+func NewWithLayout(s string, layout Layout) FileBackend {
+	return FileBackend{path: s, layout: layout}
 }
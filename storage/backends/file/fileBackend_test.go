@@ -0,0 +1,19 @@
+package fileBackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NamedKitten/kittehbooru/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	for _, layout := range []Layout{LayoutFlat, LayoutHash, LayoutPrefix} {
+		t.Run(string(layout), func(t *testing.T) {
+			fb := NewWithLayout(t.TempDir()+"/", layout)
+			for _, err := range storagetest.Check(context.Background(), fb) {
+				t.Error(err)
+			}
+		})
+	}
+}
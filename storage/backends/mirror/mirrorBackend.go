@@ -0,0 +1,108 @@
+package mirrorBackend
+
+import (
+	"context"
+	"net/http"
+	"runtime/trace"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// MirrorBackend writes to two storages while reading from the primary one,
+// used to keep a new storage backend in sync while migrating to it.
+type MirrorBackend struct {
+	primary   types.Storage
+	secondary types.Storage
+}
+
+// mirrorFile writes to both the primary and the secondary file.
+type mirrorFile struct {
+	primary   types.WriteableFile
+	secondary types.WriteableFile
+}
+
+func (f mirrorFile) Write(p []byte) (int, error) {
+	n, err := f.primary.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := f.secondary.Write(p); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (f mirrorFile) Close() error {
+	err := f.primary.Close()
+	if serr := f.secondary.Close(); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Abort discards both files if the storages support it.
+func (f mirrorFile) Abort() error {
+	err := abort(f.primary)
+	if serr := abort(f.secondary); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// abort discards a file if the storage supports it, otherwise it is closed.
+func abort(f types.WriteableFile) error {
+	if a, ok := f.(types.AbortableFile); ok {
+		return a.Abort()
+	}
+	return f.Close()
+}
+
+func (mb MirrorBackend) Open(s string) (http.File, error) {
+	f, err := mb.primary.Open(s)
+	if err != nil {
+		return mb.secondary.Open(s)
+	}
+	return f, nil
+}
+
+func (mb MirrorBackend) Delete(s string) error {
+	err := mb.primary.Delete(s)
+	if serr := mb.secondary.Delete(s); serr != nil {
+		log.Debug().Err(serr).Str("file", s).Msg("MirrorStorage can't delete from secondary")
+	}
+	return err
+}
+
+func (mb MirrorBackend) ReadFile(ctx context.Context, s string) (types.ReadableFile, error) {
+	defer trace.StartRegion(ctx, "MirrorStorage/ReadFile").End()
+	f, err := mb.primary.ReadFile(ctx, s)
+	if err != nil {
+		return mb.secondary.ReadFile(ctx, s)
+	}
+	return f, nil
+}
+
+func (mb MirrorBackend) WriteFile(ctx context.Context, s string) (types.WriteableFile, error) {
+	defer trace.StartRegion(ctx, "MirrorStorage/WriteFile").End()
+	primary, err := mb.primary.WriteFile(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := mb.secondary.WriteFile(ctx, s)
+	if err != nil {
+		abort(primary)
+		return nil, err
+	}
+	return mirrorFile{primary, secondary}, nil
+}
+
+func (mb MirrorBackend) List(ctx context.Context) ([]string, error) {
+	defer trace.StartRegion(ctx, "MirrorStorage/List").End()
+	return mb.primary.List(ctx)
+}
+
+// New creates a storage which reads from primary and writes to both primary and secondary.
+func New(primary, secondary types.Storage) MirrorBackend {
+	return MirrorBackend{primary, secondary}
+}
@@ -0,0 +1,36 @@
+package mirrorBackend
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	fileBackend "github.com/NamedKitten/kittehbooru/storage/backends/file"
+	"github.com/NamedKitten/kittehbooru/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	secondary := fileBackend.New(t.TempDir() + "/")
+	mb := New(fileBackend.New(t.TempDir()+"/"), secondary)
+	for _, err := range storagetest.Check(ctx, mb) {
+		t.Error(err)
+	}
+
+	f, err := mb.WriteFile(ctx, "mirrored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("data"))
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := secondary.ReadFile(ctx, "mirrored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, _ := ioutil.ReadAll(r); string(data) != "data" {
+		t.Errorf("secondary has %q, expected %q", data, "data")
+	}
+}
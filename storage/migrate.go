@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime/trace"
+	"strings"
+	"sync"
+
+	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
+)
+
+// MigrateOptions controls how Migrate copies files.
+type MigrateOptions struct {
+	// Workers is how many files are copied at the same time.
+	Workers int
+	// Journal is the path of a file where every verified file is recorded,
+	// so an interrupted migration can be resumed.
+	Journal string
+	// From and To are the URIs of the storages, recorded in the journal so
+	// it can't be used to resume a different migration.
+	From, To string
+}
+
+// migrateJournal records which files have been copied and verified.
+type migrateJournal struct {
+	sync.Mutex
+	f    *os.File
+	done map[string]string
+}
+
+// openJournal opens or creates a journal, reading any previously completed files.
+// The first line of a journal names the migration it belongs to, a journal
+// with a different header is refused.
+func openJournal(name, header string) (*migrateJournal, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &migrateJournal{f: f, done: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := fmt.Fprintln(f, header); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return j, nil
+	}
+	if scanner.Text() != header {
+		f.Close()
+		return nil, fmt.Errorf("Journal %s is for a different migration (%q), use another journal", name, scanner.Text())
+	}
+	for scanner.Scan() {
+		// Each line is "<sha256> <filename>".
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) == 2 {
+			j.done[parts[1]] = parts[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// record adds a verified file to the journal.
+func (j *migrateJournal) record(name, sum string) error {
+	j.Lock()
+	defer j.Unlock()
+	j.done[name] = sum
+	_, err := fmt.Fprintf(j.f, "%s %s\n", sum, name)
+	return err
+}
+
+// Migrate copies every file from one storage to another, verifying each copy
+// by comparing checksums. Files already recorded in the journal are skipped.
+func Migrate(ctx context.Context, from, to types.Storage, opts MigrateOptions) error {
+	defer trace.StartRegion(ctx, "Storage/Migrate").End()
+
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	journal, err := openJournal(opts.Journal, fmt.Sprintf("# from %s to %s", opts.From, opts.To))
+	if err != nil {
+		return err
+	}
+	defer journal.f.Close()
+
+	names, err := from.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	var failedMutex sync.Mutex
+	failed := 0
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				sum, err := migrateFile(ctx, from, to, name)
+				if err == nil {
+					err = journal.record(name, sum)
+				}
+				if err != nil {
+					log.Error().Err(err).Str("file", name).Msg("Migrate can't copy file")
+					failedMutex.Lock()
+					failed++
+					failedMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	skipped := 0
+	for i, name := range names {
+		if _, ok := journal.done[name]; ok {
+			skipped++
+			continue
+		}
+		select {
+		case work <- name:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i%1000 == 0 {
+			log.Info().Int("done", i).Int("total", len(names)).Msg("Migrating")
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	log.Info().Int("total", len(names)).Int("skipped", skipped).Int("failed", failed).Msg("Migrate finished")
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d files failed to migrate", failed)
+	}
+	return nil
+}
+
+// migrateFile copies a single file and checks that the copy has the same
+// checksum as the original, returning the checksum.
+func migrateFile(ctx context.Context, from, to types.Storage, name string) (string, error) {
+	src, err := from.ReadFile(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := to.WriteFile(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(dst, io.TeeReader(src, h)); err != nil {
+		Abort(dst)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	srcSum := h.Sum(nil)
+
+	dstSum, err := Checksum(ctx, to, name)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(srcSum, dstSum) {
+		return "", fmt.Errorf("Checksum mismatch for %s", name)
+	}
+	return hex.EncodeToString(srcSum), nil
+}
+
+// Checksum returns the SHA-256 checksum of a file in a storage.
+func Checksum(ctx context.Context, s types.Storage, name string) ([]byte, error) {
+	f, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRefusesOtherMigration(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal")
+	j, err := openJournal(name, "# from a to b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record("cat.png", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+
+	j, err = openJournal(name, "# from a to b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+	if j.done["cat.png"] != "abc" {
+		t.Errorf("resumed journal has %q, want cat.png recorded", j.done)
+	}
+
+	if j, err := openJournal(name, "# from a to c"); err == nil {
+		j.f.Close()
+		t.Error("journal for a different migration was opened")
+	}
+}
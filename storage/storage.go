@@ -1,15 +1,93 @@
 package storage
 
 import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/url"
 	"strings"
 
+	encryptedBackend "github.com/NamedKitten/kittehbooru/storage/backends/encrypted"
 	fileBackend "github.com/NamedKitten/kittehbooru/storage/backends/file"
 	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/rs/zerolog/log"
 )
 
-func GetStorage(s string) types.Storage {
+// Options are settings used by some of the storage backends.
+type Options struct {
+	// KeyFile is the path of the key used by encrypted storage.
+	KeyFile string
+}
+
+// GetStorage returns the storage for a URI, or nil if the URI isn't valid.
+// URIs starting with encrypted+ wrap the rest of the URI in a storage which
+// encrypts all files with the key from opts.KeyFile.
+// File storage URIs can end with ?layout=hash or ?layout=prefix to store
+// files in subdirectories instead of a single directory.
+func GetStorage(s string, opts Options) types.Storage {
+	if strings.HasPrefix(s, "encrypted+") {
+		inner := GetStorage(strings.TrimPrefix(s, "encrypted+"), opts)
+		if inner == nil {
+			return nil
+		}
+		key, err := ReadKeyFile(opts.KeyFile)
+		if err != nil {
+			log.Error().Err(err).Str("keyFile", opts.KeyFile).Msg("Can't read storage encryption key")
+			return nil
+		}
+		eb, err := encryptedBackend.New(inner, key)
+		if err != nil {
+			log.Error().Err(err).Msg("Can't create encrypted storage")
+			return nil
+		}
+		return eb
+	}
 	if strings.HasPrefix(s, "file://") {
-		return fileBackend.New(strings.TrimPrefix(s, "file://"))
+		path, query := splitQuery(strings.TrimPrefix(s, "file://"))
+		layout := fileBackend.Layout(query.Get("layout"))
+		switch layout {
+		case fileBackend.LayoutFlat, fileBackend.LayoutHash, fileBackend.LayoutPrefix:
+			return fileBackend.NewWithLayout(path, layout)
+		}
+		log.Error().Str("layout", string(layout)).Msg("Unknown file storage layout")
 	}
 	return nil
 }
+
+// splitQuery splits the options at the end of a storage URI from the rest of it.
+func splitQuery(s string) (string, url.Values) {
+	i := strings.LastIndex(s, "?")
+	if i == -1 {
+		return s, url.Values{}
+	}
+	query, err := url.ParseQuery(s[i+1:])
+	if err != nil {
+		log.Error().Err(err).Msg("Can't parse storage options")
+	}
+	return s[:i], query
+}
+
+// ReadKeyFile reads a 32 byte key, stored either raw or hex encoded.
+func ReadKeyFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("Key needs to be 32 bytes or 64 hex characters")
+	}
+	return key, nil
+}
+
+// Abort discards a file being written if the storage supports it,
+// otherwise the file is closed.
+func Abort(f types.WriteableFile) error {
+	if a, ok := f.(types.AbortableFile); ok {
+		return a.Abort()
+	}
+	return f.Close()
+}
@@ -0,0 +1,285 @@
+// Package storagetest checks that a types.Storage implementation behaves the
+// way the rest of kittehbooru expects it to.
+//
+// It is run against the file, encrypted and mirror backends by their tests,
+// and can be run against other backends from a test:
+//
+//	for _, err := range storagetest.Check(ctx, s) {
+//		t.Error(err)
+//	}
+//
+// or against a configured backend with `kittehbooru storage check -uri URI`.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/NamedKitten/kittehbooru/types"
+)
+
+// Prefix is the start of the name of every file created by Check.
+const Prefix = "storagetest-"
+
+// check is a single check run against a storage.
+type check struct {
+	Name string
+	Run  func(ctx context.Context, s types.Storage) error
+}
+
+var checks = []check{
+	{"WriteRead", checkWriteRead},
+	{"Overwrite", checkOverwrite},
+	{"OpenStatSeek", checkOpenStatSeek},
+	{"Empty", checkEmpty},
+	{"List", checkList},
+	{"Delete", checkDelete},
+	{"Missing", checkMissing},
+	{"InvalidNames", checkInvalidNames},
+	{"Abort", checkAbort},
+}
+
+// Check runs every check against a storage, returning a error for each
+// failed check. Files created by the checks are deleted afterwards.
+func Check(ctx context.Context, s types.Storage) []error {
+	errs := make([]error, 0)
+	for _, c := range checks {
+		if err := c.Run(ctx, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", c.Name, err))
+		}
+	}
+	return errs
+}
+
+// testData returns size bytes of data which differs between calls with different seeds.
+func testData(size int, seed byte) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7) + seed
+	}
+	return data
+}
+
+// write writes data to a file.
+func write(ctx context.Context, s types.Storage, name string, data []byte) error {
+	f, err := s.WriteFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// read reads a file using ReadFile.
+func read(ctx context.Context, s types.Storage, name string) ([]byte, error) {
+	f, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// expectContent checks a file has the right content using both ReadFile and Open.
+func expectContent(ctx context.Context, s types.Storage, name string, data []byte) error {
+	got, err := read(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("ReadFile returned %d bytes, expected %d bytes", len(got), len(data))
+	}
+
+	f, err := s.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	got, err = ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("Open returned %d bytes, expected %d bytes", len(got), len(data))
+	}
+	return nil
+}
+
+func checkWriteRead(ctx context.Context, s types.Storage) error {
+	name := Prefix + "write-read"
+	defer s.Delete(name)
+	data := testData(200*1024+3, 1)
+	if err := write(ctx, s, name, data); err != nil {
+		return err
+	}
+	return expectContent(ctx, s, name, data)
+}
+
+func checkOverwrite(ctx context.Context, s types.Storage) error {
+	name := Prefix + "overwrite"
+	defer s.Delete(name)
+	if err := write(ctx, s, name, testData(100*1024, 1)); err != nil {
+		return err
+	}
+	// A smaller file must not leave any of the old content behind.
+	data := testData(1000, 2)
+	if err := write(ctx, s, name, data); err != nil {
+		return err
+	}
+	return expectContent(ctx, s, name, data)
+}
+
+func checkOpenStatSeek(ctx context.Context, s types.Storage) error {
+	name := Prefix + "seek"
+	defer s.Delete(name)
+	data := testData(150*1024, 3)
+	if err := write(ctx, s, name, data); err != nil {
+		return err
+	}
+
+	f, err := s.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != int64(len(data)) {
+		return fmt.Errorf("Stat returned size %d, expected %d", info.Size(), len(data))
+	}
+
+	for _, offset := range []int64{0, 1, 65535, 65536, 100000, int64(len(data)) - 10} {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		buf := make([]byte, 10)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return fmt.Errorf("Read at %d: %v", offset, err)
+		}
+		if !bytes.Equal(buf, data[offset:offset+10]) {
+			return fmt.Errorf("Read at %d returned the wrong data", offset)
+		}
+	}
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end != int64(len(data)) {
+		return fmt.Errorf("Seek to end returned %d, expected %d", end, len(data))
+	}
+	return nil
+}
+
+func checkEmpty(ctx context.Context, s types.Storage) error {
+	name := Prefix + "empty"
+	defer s.Delete(name)
+	if err := write(ctx, s, name, []byte{}); err != nil {
+		return err
+	}
+	return expectContent(ctx, s, name, []byte{})
+}
+
+func checkList(ctx context.Context, s types.Storage) error {
+	name := Prefix + "list"
+	defer s.Delete(name)
+	if err := write(ctx, s, name, testData(10, 4)); err != nil {
+		return err
+	}
+	// A file which is still being written must not be listed.
+	pending, err := s.WriteFile(ctx, Prefix+"list-pending")
+	if err != nil {
+		return err
+	}
+	defer s.Delete(Prefix + "list-pending")
+	pending.Write(testData(10, 5))
+
+	names, err := s.List(ctx)
+	pending.Close()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, n := range names {
+		switch n {
+		case name:
+			found = true
+		case Prefix + "list-pending":
+			return fmt.Errorf("List returned a file which is still being written")
+		}
+	}
+	if !found {
+		return fmt.Errorf("List didn't return %s", name)
+	}
+	return nil
+}
+
+func checkDelete(ctx context.Context, s types.Storage) error {
+	name := Prefix + "delete"
+	if err := write(ctx, s, name, testData(10, 6)); err != nil {
+		return err
+	}
+	if err := s.Delete(name); err != nil {
+		return err
+	}
+	if _, err := s.ReadFile(ctx, name); err == nil {
+		return fmt.Errorf("File can still be read after being deleted")
+	}
+	return nil
+}
+
+func checkMissing(ctx context.Context, s types.Storage) error {
+	name := Prefix + "missing"
+	if _, err := s.ReadFile(ctx, name); err == nil {
+		return fmt.Errorf("ReadFile of a missing file didn't return a error")
+	}
+	if _, err := s.Open(name); !os.IsNotExist(err) {
+		return fmt.Errorf("Open of a missing file returned %v, expected a not exist error", err)
+	}
+	return nil
+}
+
+func checkInvalidNames(ctx context.Context, s types.Storage) error {
+	for _, name := range []string{"", "..", "../" + Prefix + "escape", "a/../../" + Prefix + "escape", "/" + Prefix + "escape"} {
+		f, err := s.WriteFile(ctx, name)
+		if err == nil {
+			f.Close()
+			s.Delete(name)
+			return fmt.Errorf("WriteFile accepted the invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+func checkAbort(ctx context.Context, s types.Storage) error {
+	name := Prefix + "abort"
+	defer s.Delete(name)
+	data := testData(1000, 7)
+	if err := write(ctx, s, name, data); err != nil {
+		return err
+	}
+	f, err := s.WriteFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	a, ok := f.(types.AbortableFile)
+	if !ok {
+		// Not all storages can discard a file.
+		return f.Close()
+	}
+	a.Write(testData(10, 8))
+	if err := a.Abort(); err != nil {
+		return err
+	}
+	return expectContent(ctx, s, name, data)
+}
@@ -1,13 +1,17 @@
 package templates
 
 import (
+	"context"
 	tmplHTML "html/template"
+	"strconv"
 	"strings"
 	tmpl "text/template"
+	"time"
 
 	"github.com/NamedKitten/kittehbooru/database"
 	"github.com/NamedKitten/kittehbooru/i18n"
 	"github.com/NamedKitten/kittehbooru/types"
+	"github.com/NamedKitten/kittehbooru/utils"
 )
 
 var DB *database.DB
@@ -59,6 +63,58 @@ func getTemplateFuncs() tmpl.FuncMap {
 		"thumbnailURL": func() string {
 			return DB.Settings.ThumbnailURL
 		},
+		"ratings": func() []string {
+			return types.Ratings
+		},
+		"ratingName": func(rating string) string {
+			switch rating {
+			case types.RatingSafe:
+				return "RatingSafe"
+			case types.RatingQuestionable:
+				return "RatingQuestionable"
+			}
+			return "RatingExplicit"
+		},
+		"defaultRating": func() string {
+			return DB.DefaultRating()
+		},
+		"viewerRatings": func(u types.User) []string {
+			return DB.ViewerRatings(u)
+		},
+		"tagCategories": func() []types.TagCategory {
+			return DB.TagCategories(context.Background())
+		},
+		"tagCategory": func(tag string) types.TagCategory {
+			return DB.TagCategory(context.Background(), tag)
+		},
+		"wiki":        utils.RenderWiki,
+		"wikiSummary": utils.RenderWikiSummary,
+		"wikiURL":     utils.WikiURL,
+		"contains": func(list []string, s string) bool {
+			for _, l := range list {
+				if l == s {
+					return true
+				}
+			}
+			return false
+		},
+		"formatTime": func(millis int64) string {
+			return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04 MST")
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"formatBytes": func(b int64) string {
+			return utils.FormatBytes(b)
+		},
+		"contentFileURL": func(filename, ext string) string {
+			name := filename + "." + ext
+			return DB.Settings.ContentURL + name + DB.SignURL("content", name)
+		},
+		"thumbnailFileURL": func(postID int64) string {
+			name := strconv.FormatInt(postID, 10) + ".webp"
+			return DB.Settings.ThumbnailURL + name + DB.SignURL("thumbnail", name)
+		},
 	}
 
 }
@@ -19,11 +19,40 @@ type WriteableFile interface {
 	io.WriteCloser
 }
 
+// AbortableFile is a WriteableFile which can be discarded instead of saved,
+// leaving any previous file with the same name in place.
+type AbortableFile interface {
+	WriteableFile
+	Abort() error
+}
+
 type Storage interface {
 	ReadFile(context.Context, string) (ReadableFile, error)
 	WriteFile(context.Context, string) (WriteableFile, error)
 	Open(string) (http.File, error)
 	Delete(string) error
+	// List returns the names of all files in the storage.
+	List(context.Context) ([]string, error)
+}
+
+// Content ratings of posts, from safe to explicit.
+const (
+	RatingSafe         = "s"
+	RatingQuestionable = "q"
+	RatingExplicit     = "e"
+)
+
+// Ratings are all the content ratings a post can have.
+var Ratings = []string{RatingSafe, RatingQuestionable, RatingExplicit}
+
+// ValidRating checks if a string is one of Ratings.
+func ValidRating(rating string) bool {
+	for _, r := range Ratings {
+		if r == rating {
+			return true
+		}
+	}
+	return false
 }
 
 type Session struct {
@@ -47,6 +76,20 @@ type User struct {
 	Posts []int64 `json:"posts"`
 	// Theme is a string of the theme.
 	Theme string `json:"theme"`
+	// StorageQuota overrides Settings.StorageQuota for this user in MiB.
+	// 0 uses the default and -1 removes the limit.
+	StorageQuota int64 `json:"storageQuota"`
+	// PostQuota overrides Settings.PostQuota for this user.
+	// 0 uses the default and -1 removes the limit.
+	PostQuota int64 `json:"postQuota"`
+	// UploadsPerHour overrides Settings.UploadsPerHour for this user.
+	// 0 uses the default and -1 removes the limit.
+	UploadsPerHour int64 `json:"uploadsPerHour"`
+	// Ratings are the post ratings shown to the user when searching,
+	// if empty Settings.DefaultRatings is used.
+	Ratings []string `json:"ratings"`
+	// Blacklist are tags of posts which are hidden from the user.
+	Blacklist []string `json:"blacklist"`
 }
 
 type Post struct {
@@ -67,4 +110,202 @@ type Post struct {
 	CreatedAt int64 `json:"timestamp"`
 	// MimeType is the MIME type of the post file.
 	MimeType string `json:"mimetype"`
+	// Size is the size of the post file in bytes.
+	Size int64 `json:"size"`
+	// Rating is the content rating of the post, one of Ratings.
+	Rating string `json:"rating"`
+	// Favorites is how many users favorited the post.
+	Favorites int64 `json:"favorites"`
+	// Score is the number of up votes minus the number of down votes.
+	Score int64 `json:"score"`
+	// Parent is the ID of the post this is a variant of, or 0 if it has none.
+	Parent int64 `json:"parent"`
+}
+
+type Comment struct {
+	// ID of the comment.
+	ID int64 `json:"id"`
+	// PostID is the ID of the post the comment is on.
+	PostID int64 `json:"postID"`
+	// Username of the user who wrote the comment.
+	Username string `json:"username"`
+	// Body is the text of the comment.
+	Body string `json:"body"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the comment was posted.
+	CreatedAt int64 `json:"timestamp"`
+	// EditedAt is the Unix timestamp in milliseconds of the last edit, or 0 if never edited.
+	EditedAt int64 `json:"editedAt"`
+}
+
+// NoteRect is the region of a image a note covers, in pixels of the original image.
+type NoteRect struct {
+	X      int64 `json:"x"`
+	Y      int64 `json:"y"`
+	Width  int64 `json:"width"`
+	Height int64 `json:"height"`
+}
+
+type Note struct {
+	// ID of the note.
+	ID int64 `json:"id"`
+	// PostID is the ID of the post the note is on.
+	PostID int64 `json:"postID"`
+	// Username of the user who created the note.
+	Username string `json:"username"`
+	NoteRect
+	// Body is the text of the note.
+	Body string `json:"body"`
+	// Version is how many times the note has been created or edited.
+	Version int64 `json:"version"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the note was created.
+	CreatedAt int64 `json:"timestamp"`
+	// EditedAt is the Unix timestamp in milliseconds of the last edit, or 0 if never edited.
+	EditedAt int64 `json:"editedAt"`
+}
+
+type NoteVersion struct {
+	// NoteID is the ID of the note this is a version of.
+	NoteID int64 `json:"noteID"`
+	// PostID is the ID of the post the note is on.
+	PostID int64 `json:"postID"`
+	// Version is the version number, starting at 1.
+	Version int64 `json:"version"`
+	// Username of the user who made this version.
+	Username string `json:"username"`
+	NoteRect
+	// Body is the text of the note in this version.
+	Body string `json:"body"`
+	// Deleted is true if this version deleted the note.
+	Deleted bool `json:"deleted"`
+	// CreatedAt is the Unix timestamp in milliseconds of when this version was made.
+	CreatedAt int64 `json:"timestamp"`
+}
+
+type Pool struct {
+	// ID of the pool.
+	ID int64 `json:"id"`
+	// Name of the pool.
+	Name string `json:"name"`
+	// Description of the pool.
+	Description string `json:"description"`
+	// Owner is the username of the user who created the pool.
+	Owner string `json:"owner"`
+	// Posts are the IDs of the posts in the pool, in order.
+	Posts []int64 `json:"posts,omitempty"`
+	// PostCount is how many posts are in the pool.
+	PostCount int64 `json:"postCount"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the pool was created.
+	CreatedAt int64 `json:"timestamp"`
+	// UpdatedAt is the Unix timestamp in milliseconds of when the pool was last changed.
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// PoolNav is a pool a post is in along with the posts either side of it.
+type PoolNav struct {
+	PoolID int64
+	Name   string
+	// Prev is the ID of the post before this one, or 0 if it is the first.
+	Prev int64
+	// Next is the ID of the post after this one, or 0 if it is the last.
+	Next int64
+}
+
+// Tag alias statuses, a alias is only used once it is approved.
+const (
+	AliasPending = "pending"
+	AliasActive  = "active"
+)
+
+type TagAlias struct {
+	// Antecedent is the tag which is replaced.
+	Antecedent string `json:"antecedent"`
+	// Consequent is the tag it is replaced with.
+	Consequent string `json:"consequent"`
+	// Status is AliasPending or AliasActive.
+	Status string `json:"status"`
+	// Creator is the username of the user who proposed the alias.
+	Creator string `json:"creator"`
+	// Approver is the username of the admin who approved the alias.
+	Approver string `json:"approver"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the alias was proposed.
+	CreatedAt int64 `json:"timestamp"`
+}
+
+type TagImplication struct {
+	// Antecedent is the tag which implies the consequent.
+	Antecedent string `json:"antecedent"`
+	// Consequent is the tag added to posts with the antecedent.
+	Consequent string `json:"consequent"`
+	// Creator is the username of the admin who added the implication.
+	Creator string `json:"creator"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the implication was added.
+	CreatedAt int64 `json:"timestamp"`
+}
+
+// GeneralCategory is the category of tags without a namespace.
+const GeneralCategory = "general"
+
+type TagCategory struct {
+	// Name is the category's name, which is also the namespace tags in it
+	// start with, like artist for artist:foo.
+	Name string `json:"name"`
+	// Colour is the CSS colour tags in the category are shown in.
+	Colour string `json:"colour"`
+	// Order is where the category is shown in tag lists, lowest first.
+	Order int `json:"order"`
+}
+
+type WikiPage struct {
+	// Tag is the tag the page describes.
+	Tag string `json:"tag"`
+	// Body is the page's text, which uses the wiki's markdown-like syntax.
+	Body string `json:"body"`
+	// Version is the number of the latest revision, starting from 1.
+	Version int64 `json:"version"`
+	// Username is the username of the user who last edited the page.
+	Username string `json:"username"`
+	// Locked pages can only be edited by admins.
+	Locked bool `json:"locked"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the page was created.
+	CreatedAt int64 `json:"timestamp"`
+	// UpdatedAt is the Unix timestamp in milliseconds of when the page was last edited.
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// WikiRevision is the body of a wiki page after a edit.
+type WikiRevision struct {
+	Tag      string `json:"tag"`
+	Version  int64  `json:"version"`
+	Username string `json:"username"`
+	Body     string `json:"body"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the edit was made.
+	CreatedAt int64 `json:"timestamp"`
+}
+
+// TagRename renames a tag in a mass tag edit.
+type TagRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MassTagEdit is a change to the tags of every post matching a search.
+type MassTagEdit struct {
+	// ID of the edit.
+	ID int64 `json:"id"`
+	// Username is the username of the admin who made the edit.
+	Username string `json:"username"`
+	// Query is the search the edited posts matched.
+	Query string `json:"query"`
+	// Add are the tags added to every post.
+	Add []string `json:"add"`
+	// Remove are the tags removed from every post.
+	Remove []string `json:"remove"`
+	// Renames are applied before tags are removed and added.
+	Renames []TagRename `json:"renames"`
+	// PostCount is how many posts were changed.
+	PostCount int64 `json:"postCount"`
+	// CreatedAt is the Unix timestamp in milliseconds of when the edit was made.
+	CreatedAt int64 `json:"timestamp"`
+	// UndoneBy is the username of the admin who undid the edit, or empty if it hasn't been.
+	UndoneBy string `json:"undoneBy"`
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 
@@ -15,6 +16,18 @@ func FilterString(s string) string {
 	return s
 }
 
+var tagFilter = regexp.MustCompile(`[^\p{L}\p{N}\p{Z}:_<>=.*-]+`)
+
+// FilterTag normalises a search term which isn't a stored tag, keeping the
+// numbers and characters used by metatags such as score:>=10 which
+// FilterString would remove.
+func FilterTag(s string) string {
+	s = tagFilter.ReplaceAllLiteralString(s, "")
+	s = strings.TrimSpace(s)
+	s = strings.ToLower(s)
+	return s
+}
+
 func SplitTagsString(tags string) []string {
 	tags = strings.Replace(tags, "+", " ", -1)
 	tags = strings.Replace(tags, ", ", " ", -1)
@@ -40,3 +53,17 @@ func RemoveFromSlice(slice []int64, toDelete int64) []int64 {
 	}
 	return newSlice
 }
+
+// FormatBytes formats a size in bytes using binary units, such as 1.5 MiB.
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"html"
+	tmplHTML "html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// wikiLinkRegex matches links to wiki pages like [[tag]] or [[tag|text]],
+	// posts like post #123 and URLs. They are matched together so the
+	// earliest one wins and a link is never rendered inside another.
+	wikiLinkRegex = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]+))?\]\]|\bpost #(\d+)|\bhttps?://[^\s<>"]+`)
+	wikiBoldRegex = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	// wikiItalicRegex only matches after bold text has been replaced.
+	wikiItalicRegex = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// WikiURL returns the URL of a tag's wiki page.
+func WikiURL(tag string) string {
+	return "/wiki/" + url.PathEscape(tag)
+}
+
+// renderWikiEmphasis renders bold and italic text in escaped wiki text.
+func renderWikiEmphasis(text string) string {
+	text = wikiBoldRegex.ReplaceAllString(text, "<b>$1</b>")
+	return wikiItalicRegex.ReplaceAllString(text, "<i>$1</i>")
+}
+
+// renderWikiLink renders a link matched by wikiLinkRegex in escaped wiki text.
+func renderWikiLink(link string) string {
+	m := wikiLinkRegex.FindStringSubmatch(link)
+	switch {
+	case m[1] != "":
+		tag := FilterString(strings.Replace(html.UnescapeString(m[1]), " ", "_", -1))
+		name := m[1]
+		if m[2] != "" {
+			name = m[2]
+		}
+		return `<a href="` + tmplHTML.HTMLEscapeString(WikiURL(tag)) + `">` + name + `</a>`
+	case m[3] != "":
+		return `<a href="/view/` + m[3] + `">post #` + m[3] + `</a>`
+	default:
+		return `<a href="` + link + `" rel="nofollow">` + link + `</a>`
+	}
+}
+
+// renderWikiInline renders the links and emphasis in a line of wiki text.
+// The text is escaped first so none of it can be used as HTML, and
+// emphasis is only rendered outside of links.
+func renderWikiInline(text string) string {
+	text = tmplHTML.HTMLEscapeString(text)
+	var b strings.Builder
+	last := 0
+	for _, loc := range wikiLinkRegex.FindAllStringIndex(text, -1) {
+		b.WriteString(renderWikiEmphasis(text[last:loc[0]]))
+		b.WriteString(renderWikiLink(text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	b.WriteString(renderWikiEmphasis(text[last:]))
+	return b.String()
+}
+
+// RenderWiki renders a wiki page's body. Lines starting with # are
+// headings, lines starting with - or * are list items and blank lines
+// separate paragraphs.
+func RenderWiki(body string) tmplHTML.HTML {
+	var b strings.Builder
+	inParagraph, inList := false, false
+	closeBlocks := func() {
+		if inParagraph {
+			b.WriteString("</p>\n")
+			inParagraph = false
+		}
+		if inList {
+			b.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+	for _, line := range strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimRight(line, " \t")
+		switch {
+		case line == "":
+			closeBlocks()
+		case strings.HasPrefix(line, "#"):
+			closeBlocks()
+			level := len(line) - len(strings.TrimLeft(line, "#"))
+			if level > 3 {
+				level = 3
+			}
+			tag := []string{"", "h4", "h5", "h6"}[level]
+			b.WriteString("<" + tag + ">" + renderWikiInline(strings.TrimSpace(strings.TrimLeft(line, "#"))) + "</" + tag + ">\n")
+		case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* "):
+			if inParagraph {
+				closeBlocks()
+			}
+			if !inList {
+				b.WriteString("<ul>\n")
+				inList = true
+			}
+			b.WriteString("<li>" + renderWikiInline(line[2:]) + "</li>\n")
+		default:
+			if inList {
+				closeBlocks()
+			}
+			if inParagraph {
+				b.WriteString("<br>\n")
+			} else {
+				b.WriteString("<p>")
+				inParagraph = true
+			}
+			b.WriteString(renderWikiInline(line))
+		}
+	}
+	closeBlocks()
+	return tmplHTML.HTML(b.String())
+}
+
+// RenderWikiSummary renders the first paragraph of a wiki page's body.
+func RenderWikiSummary(body string) tmplHTML.HTML {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			if len(lines) != 0 {
+				break
+			}
+			continue
+		}
+		lines = append(lines, renderWikiInline(line))
+	}
+	return tmplHTML.HTML(strings.Join(lines, "<br>\n"))
+}
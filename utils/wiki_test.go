@@ -0,0 +1,42 @@
+package utils
+
+import "testing"
+
+func TestRenderWikiInline(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"<b>&", "&lt;b&gt;&amp;"},
+		{"**bold** and *italic*", "<b>bold</b> and <i>italic</i>"},
+		{"see [[cat ears]]", `see <a href="/wiki/cat_ears">cat ears</a>`},
+		{"see [[cat_ears|ears]]", `see <a href="/wiki/cat_ears">ears</a>`},
+		{"see [[a=b]]", `see <a href="/wiki/ab">a=b</a>`},
+		{`[["><script>]]`, `<a href="/wiki/script">&#34;&gt;&lt;script&gt;</a>`},
+		{"like post #123.", `like <a href="/view/123">post #123</a>.`},
+		{"go to https://example.com/a", `go to <a href="https://example.com/a" rel="nofollow">https://example.com/a</a>`},
+		{"http://x/[[foo]]", `<a href="http://x/[[foo]]" rel="nofollow">http://x/[[foo]]</a>`},
+		{`http://x/[[a"b]]`, `<a href="http://x/[[a&#34;b]]" rel="nofollow">http://x/[[a&#34;b]]</a>`},
+		{"http://x/post #1", `<a href="http://x/post" rel="nofollow">http://x/post</a> #1`},
+		{"http://x/*a*", `<a href="http://x/*a*" rel="nofollow">http://x/*a*</a>`},
+		{"[[http://x/]]", `<a href="/wiki/http:x">http://x/</a>`},
+		{"*[[tag]]*", `*<a href="/wiki/tag">tag</a>*`},
+	}
+	for _, test := range tests {
+		if got := renderWikiInline(test.text); got != test.want {
+			t.Errorf("renderWikiInline(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestRenderWiki(t *testing.T) {
+	body := "# Title\nfirst line\nsecond line\n\n- one\n- [[two]]\n\nlast"
+	want := "<h4>Title</h4>\n<p>first line<br>\nsecond line</p>\n<ul>\n<li>one</li>\n<li><a href=\"/wiki/two\">two</a></li>\n</ul>\n<p>last</p>\n"
+	if got := string(RenderWiki(body)); got != want {
+		t.Errorf("RenderWiki() = %q, want %q", got, want)
+	}
+	if got := string(RenderWikiSummary("# Title\n\nfirst\nsecond\n\nthird")); got != "first<br>\nsecond" {
+		t.Errorf("RenderWikiSummary() = %q", got)
+	}
+}